@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime/debug"
+	"strings"
 
 	"github.com/suifei/ocr-server/internal/config"
 	"github.com/suifei/ocr-server/internal/server"
@@ -32,8 +33,52 @@ var (
 	logMaxBackups    = flag.Int("log-max-backups", 0, "最大日志文件备份数")
 	logMaxAge        = flag.Int("log-max-age", 0, "最大日志文件保留天数")
 	logCompress      = flag.Bool("log-compress", false, "是否压缩日志文件")
+	logLevel         = flag.String("log-level", "", "最低日志级别（info、warning、error）")
+	logFormat        = flag.String("log-format", "", "日志格式（text、json）")
 	thresholdMode    = flag.Int("threshold-mode", 0, "二值化阈值模式 0 binary,1 otsu")
 	thresholdValue   = flag.Int("threshold-value", 100, "二值化阈值 0-255")
+	maxImageWidth    = flag.Int("max-image-width", 0, "图像最大宽度（像素）")
+	maxImageHeight   = flag.Int("max-image-height", 0, "图像最大高度（像素）")
+	maxImagePixels   = flag.Int64("max-image-pixels", 0, "图像最大像素数")
+	maxBodyBytes     = flag.Int64("max-body-bytes", 0, "请求体最大字节数")
+	maxImageBytes    = flag.Int64("max-image-bytes", 0, "解码后图像数据最大字节数")
+	allowedImageDirs = flag.String("allowed-image-dirs", "", "允许 image_path 访问的目录，多个目录用逗号分隔")
+	disableImagePath = flag.Bool("disable-image-path", false, "是否禁用 image_path 参数")
+	allowedURLHosts  = flag.String("allowed-url-hosts", "", "允许 image_url 访问的主机，多个主机用逗号分隔，支持 *.example.com")
+	urlFetchTimeout  = flag.Duration("url-fetch-timeout", 0, "下载 image_url 的超时时间")
+	urlMaxRedirects  = flag.Int("url-max-redirects", -1, "下载 image_url 允许的最大重定向次数")
+
+	autoscaleInterval  = flag.Duration("autoscale-interval", -1, "自动伸缩采样间隔，0 表示禁用自动伸缩")
+	scaleUpCooldown    = flag.Duration("scale-up-cooldown", 0, "两次扩容之间的最短间隔")
+	scaleDownCooldown  = flag.Duration("scale-down-cooldown", 0, "两次缩容之间的最短间隔")
+	scaleWaitThreshold = flag.Duration("scale-wait-threshold", 0, "触发扩容的平均排队等待时间")
+
+	priorityWeightHigh   = flag.Int("priority-weight-high", 0, "high 优先级队列调度权重")
+	priorityWeightNormal = flag.Int("priority-weight-normal", 0, "normal 优先级队列调度权重")
+	priorityWeightBulk   = flag.Int("priority-weight-bulk", 0, "bulk 优先级队列调度权重")
+
+	enqueueWait  = flag.Duration("enqueue-wait", -1, "队列已满时等待入队的最长时间，0 表示立即拒绝")
+	admissionSLO = flag.Bool("admission-slo", false, "是否拒绝预计无法在 timeout_ms 内完成的请求")
+
+	maxJobsPerProcessor = flag.Int64("max-jobs-per-processor", 0, "处理器处理多少个任务后回收，0 表示不限制")
+	maxProcessorAge     = flag.Duration("max-processor-age", 0, "处理器最长存活时间，0 表示不限制")
+	maxRSSMB            = flag.Int("max-rss-mb", 0, "处理器进程常驻内存上限（MB），0 表示不限制")
+
+	breakerThreshold = flag.Int("breaker-threshold", -1, "引擎连续失败多少次后打开熔断器，0 表示禁用")
+	breakerCooldown  = flag.Duration("breaker-cooldown", 0, "熔断器打开后等待多久开始探测引擎")
+
+	adminToken = flag.String("admin-token", "", "管理接口的访问令牌，为空时禁用管理接口")
+
+	cacheMaxEntries   = flag.Int("cache-max-entries", -1, "结果缓存的最大条目数，0 表示禁用缓存")
+	cacheMaxBytes     = flag.Int64("cache-max-bytes", 0, "结果缓存的最大内存占用（字节）")
+	cacheTTL          = flag.Duration("cache-ttl", 0, "缓存结果的有效期")
+	cacheDir          = flag.String("cache-dir", "", "磁盘缓存目录，为空时只使用内存缓存")
+	cacheDiskMaxBytes = flag.Int64("cache-disk-max-bytes", 0, "磁盘缓存的最大占用（字节）")
+
+	traceExporter    = flag.String("trace-exporter", "", "链路追踪导出方式（stdout、file、otlp），为空时禁用")
+	traceFile        = flag.String("trace-file", "", "trace-exporter 为 file 时写入的文件")
+	traceEndpoint    = flag.String("trace-endpoint", "", "OTLP/HTTP 收集器地址，例如 http://localhost:4318")
+	traceSampleRatio = flag.Float64("trace-sample-ratio", -1, "新链路的采样比例 0-1")
 )
 
 func main() {
@@ -74,6 +119,9 @@ func main() {
 	}
 
 	utils.SetupLogger(cfg)
+	if err := utils.SetLogLevel(cfg.LogLevel); err != nil {
+		utils.LogWarning("%v", err)
+	}
 
 	utils.LogInfo("启动 OCR 服务器 (版本 %s)...", version)
 
@@ -83,6 +131,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 命令行参数在热加载时同样优先于配置文件
+	srv.EnableReload(func() (config.Config, error) {
+		cfg, err := config.Reload()
+		if err != nil {
+			return config.Config{}, err
+		}
+		applyCommandLineArgs(&cfg)
+		return cfg, nil
+	})
+
 	if err := srv.Initialize(); err != nil {
 		utils.LogError("初始化服务器失败: %v", err)
 		os.Exit(1)
@@ -134,6 +192,12 @@ func applyCommandLineArgs(cfg *config.Config) {
 	if *logMaxBackups != 0 {
 		cfg.LogMaxBackups = *logMaxBackups
 	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if *logFormat != "" {
+		cfg.LogFormat = *logFormat
+	}
 	if *logMaxAge != 0 {
 		cfg.LogMaxAge = *logMaxAge
 	}
@@ -143,6 +207,108 @@ func applyCommandLineArgs(cfg *config.Config) {
 	if *thresholdValue != 100 {
 		cfg.ThresholdValue = *thresholdValue
 	}
+	if *maxImageWidth != 0 {
+		cfg.MaxImageWidth = *maxImageWidth
+	}
+	if *maxImageHeight != 0 {
+		cfg.MaxImageHeight = *maxImageHeight
+	}
+	if *maxImagePixels != 0 {
+		cfg.MaxImagePixels = *maxImagePixels
+	}
+	if *maxBodyBytes != 0 {
+		cfg.MaxBodyBytes = *maxBodyBytes
+	}
+	if *maxImageBytes != 0 {
+		cfg.MaxImageBytes = *maxImageBytes
+	}
+	if *allowedImageDirs != "" {
+		cfg.AllowedImageDirs = strings.Split(*allowedImageDirs, ",")
+	}
+	if *disableImagePath {
+		cfg.DisableImagePath = true
+	}
+	if *allowedURLHosts != "" {
+		cfg.AllowedURLHosts = strings.Split(*allowedURLHosts, ",")
+	}
+	if *urlFetchTimeout != 0 {
+		cfg.URLFetchTimeout = *urlFetchTimeout
+	}
+	if *urlMaxRedirects >= 0 {
+		cfg.URLMaxRedirects = *urlMaxRedirects
+	}
+	if *autoscaleInterval >= 0 {
+		cfg.AutoscaleInterval = *autoscaleInterval
+	}
+	if *scaleUpCooldown != 0 {
+		cfg.ScaleUpCooldown = *scaleUpCooldown
+	}
+	if *scaleDownCooldown != 0 {
+		cfg.ScaleDownCooldown = *scaleDownCooldown
+	}
+	if *scaleWaitThreshold != 0 {
+		cfg.ScaleWaitThreshold = *scaleWaitThreshold
+	}
+	if *priorityWeightHigh != 0 {
+		cfg.PriorityWeightHigh = *priorityWeightHigh
+	}
+	if *priorityWeightNormal != 0 {
+		cfg.PriorityWeightNormal = *priorityWeightNormal
+	}
+	if *priorityWeightBulk != 0 {
+		cfg.PriorityWeightBulk = *priorityWeightBulk
+	}
+	if *enqueueWait >= 0 {
+		cfg.EnqueueWait = *enqueueWait
+	}
+	if *admissionSLO {
+		cfg.AdmissionSLO = true
+	}
+	if *maxJobsPerProcessor != 0 {
+		cfg.MaxJobsPerProcessor = *maxJobsPerProcessor
+	}
+	if *maxProcessorAge != 0 {
+		cfg.MaxProcessorAge = *maxProcessorAge
+	}
+	if *maxRSSMB != 0 {
+		cfg.MaxRSSMB = *maxRSSMB
+	}
+	if *breakerThreshold >= 0 {
+		cfg.BreakerThreshold = *breakerThreshold
+	}
+	if *breakerCooldown != 0 {
+		cfg.BreakerCooldown = *breakerCooldown
+	}
+	if *adminToken != "" {
+		cfg.AdminToken = *adminToken
+	}
+	if *cacheMaxEntries >= 0 {
+		cfg.CacheMaxEntries = *cacheMaxEntries
+	}
+	if *cacheMaxBytes != 0 {
+		cfg.CacheMaxBytes = *cacheMaxBytes
+	}
+	if *cacheTTL != 0 {
+		cfg.CacheTTL = *cacheTTL
+	}
+	if *cacheDir != "" {
+		cfg.CacheDir = *cacheDir
+	}
+	if *cacheDiskMaxBytes != 0 {
+		cfg.CacheDiskMaxBytes = *cacheDiskMaxBytes
+	}
+	if *traceExporter != "" {
+		cfg.TraceExporter = *traceExporter
+	}
+	if *traceFile != "" {
+		cfg.TraceFile = *traceFile
+	}
+	if *traceEndpoint != "" {
+		cfg.TraceEndpoint = *traceEndpoint
+	}
+	if *traceSampleRatio >= 0 {
+		cfg.TraceSampleRatio = *traceSampleRatio
+	}
 
 	cfg.LogCompress = *logCompress
 }
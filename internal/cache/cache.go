@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Options configures a Cache
+type Options struct {
+	// MaxEntries limits the number of in-memory entries, 0 means unlimited
+	MaxEntries int
+	// MaxBytes limits the total size of in-memory values, 0 means unlimited
+	MaxBytes int64
+	// TTL is how long an entry stays valid, 0 means entries never expire
+	TTL time.Duration
+	// Dir enables the on-disk backend when not empty
+	Dir string
+	// DiskMaxBytes limits the total size of the on-disk backend, 0 means unlimited
+	DiskMaxBytes int64
+}
+
+// Stats is a snapshot of cache counters
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	DiskHits  int64 `json:"disk_hits"`
+	Misses    int64 `json:"misses"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	Evictions int64 `json:"evictions"`
+	DiskBytes int64 `json:"disk_bytes"`
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Cache is a content-addressed LRU cache with optional TTL and on-disk backend.
+// It is safe for concurrent use.
+type Cache struct {
+	opts Options
+	disk *diskStore
+
+	mu    sync.Mutex
+	ll    *list.List // front is most recently used
+	items map[string]*list.Element
+	bytes int64
+	stats Stats
+}
+
+// New creates a cache; the on-disk directory is created if needed
+func New(opts Options) (*Cache, error) {
+	c := &Cache{
+		opts:  opts,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+	if opts.Dir != "" {
+		disk, err := newDiskStore(opts.Dir, opts.DiskMaxBytes, opts.TTL)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+	return c, nil
+}
+
+// Key derives a cache key from the content and the options that affect the result
+func Key(data []byte, options string) string {
+	h := sha256.New()
+	h.Write(data)
+	h.Write([]byte{0})
+	h.Write([]byte(options))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the value stored under key, checking memory first and then disk
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		if c.opts.TTL <= 0 || time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return e.value, true
+		}
+		c.removeElement(el)
+	}
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		if value, expires, ok := c.disk.get(key); ok {
+			c.mu.Lock()
+			c.stats.Hits++
+			c.stats.DiskHits++
+			c.addLocked(key, value, expires)
+			c.mu.Unlock()
+			return value, true
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+// Put stores value under key in memory and, if enabled, on disk
+func (c *Cache) Put(key string, value []byte) {
+	var expires time.Time
+	if c.opts.TTL > 0 {
+		expires = time.Now().Add(c.opts.TTL)
+	}
+
+	c.mu.Lock()
+	c.addLocked(key, value, expires)
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		c.disk.put(key, value)
+	}
+}
+
+// Stats returns the current counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	stats := c.stats
+	stats.Entries = c.ll.Len()
+	stats.Bytes = c.bytes
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		stats.DiskBytes = c.disk.size()
+	}
+	return stats
+}
+
+func (c *Cache) addLocked(key string, value []byte, expires time.Time) {
+	if c.opts.MaxBytes > 0 && int64(len(value)) > c.opts.MaxBytes {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	c.bytes += int64(len(value))
+
+	for c.ll.Len() > 0 &&
+		((c.opts.MaxEntries > 0 && c.ll.Len() > c.opts.MaxEntries) ||
+			(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)) {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= int64(len(e.value))
+}
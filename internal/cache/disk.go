@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskStore keeps one file per key at <dir>/<first 2 hex digits>/<64 hex digits>; the file
+// modification time marks when it was written. An in-memory index of the files, oldest write
+// first, lets expiry and pruning work from the front of the list instead of walking the
+// directory on every write. Files outside that layout are never indexed or removed, so a
+// misconfigured directory cannot cost the operator anything but disk space.
+type diskStore struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List               // of *diskEntry, oldest write first
+	entries map[string]*list.Element // keyed by file path
+}
+
+type diskEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+var (
+	// diskKeyPattern matches the keys produced by Key and hence the cache's file names
+	diskKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// diskShardPattern matches the shard directories holding the files
+	diskShardPattern = regexp.MustCompile(`^[0-9a-f]{2}$`)
+	// diskTempPattern matches the temporary files left behind by an interrupted put
+	diskTempPattern = regexp.MustCompile(`^[0-9a-f]{64}\.tmp[0-9]+$`)
+)
+
+// diskLowWater is the fraction of maxBytes pruning reduces the store to, so that
+// a store at its cap is not pruned again on the very next write
+const diskLowWater = 0.9
+
+func newDiskStore(dir string, maxBytes int64, ttl time.Duration) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d := &diskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	files := d.files()
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range files {
+		d.addLocked(f)
+	}
+	d.pruneLocked(time.Now())
+	return d, nil
+}
+
+func (d *diskStore) path(key string) string {
+	return filepath.Join(d.dir, key[:2], key)
+}
+
+func (d *diskStore) get(key string) ([]byte, time.Time, bool) {
+	if !diskKeyPattern.MatchString(key) {
+		return nil, time.Time{}, false
+	}
+	p := d.path(key)
+	now := time.Now()
+
+	d.mu.Lock()
+	el, ok := d.entries[p]
+	var expires time.Time
+	if ok && d.ttl > 0 {
+		expires = el.Value.(*diskEntry).modTime.Add(d.ttl)
+		if now.After(expires) {
+			d.removeLocked(el)
+			ok = false
+		}
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	value, err := os.ReadFile(p)
+	if err != nil {
+		// the file was removed behind our back; forget it
+		d.mu.Lock()
+		if el, ok := d.entries[p]; ok {
+			d.unlinkLocked(el)
+		}
+		d.mu.Unlock()
+		return nil, time.Time{}, false
+	}
+	return value, expires, true
+}
+
+func (d *diskStore) put(key string, value []byte) {
+	if d.maxBytes > 0 && int64(len(value)) > d.maxBytes || !diskKeyPattern.MatchString(key) {
+		return
+	}
+
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return
+	}
+
+	// write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(p), key+".tmp*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.entries[p]; ok {
+		d.unlinkLocked(el)
+	}
+	d.addLocked(diskEntry{path: p, size: int64(len(value)), modTime: now})
+	d.pruneLocked(now)
+}
+
+// addLocked appends an entry as the newest write
+func (d *diskStore) addLocked(f diskEntry) {
+	d.entries[f.path] = d.order.PushBack(&f)
+	d.bytes += f.size
+}
+
+// unlinkLocked drops an entry from the index without touching the file
+func (d *diskStore) unlinkLocked(el *list.Element) {
+	entry := d.order.Remove(el).(*diskEntry)
+	delete(d.entries, entry.path)
+	d.bytes -= entry.size
+}
+
+// removeLocked deletes the entry's file and drops it from the index
+func (d *diskStore) removeLocked(el *list.Element) {
+	os.Remove(el.Value.(*diskEntry).path)
+	d.unlinkLocked(el)
+}
+
+// pruneLocked removes expired entries and, once the store exceeds maxBytes, the oldest
+// entries until it is back under the low-water mark. Both work from the oldest write,
+// so each call only touches the entries it removes.
+func (d *diskStore) pruneLocked(now time.Time) {
+	if d.ttl > 0 {
+		for el := d.order.Front(); el != nil; el = d.order.Front() {
+			if now.Sub(el.Value.(*diskEntry).modTime) <= d.ttl {
+				break
+			}
+			d.removeLocked(el)
+		}
+	}
+
+	if d.maxBytes <= 0 || d.bytes <= d.maxBytes {
+		return
+	}
+	lowWater := int64(float64(d.maxBytes) * diskLowWater)
+	for el := d.order.Front(); el != nil && d.bytes > lowWater; el = d.order.Front() {
+		d.removeLocked(el)
+	}
+}
+
+func (d *diskStore) size() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytes
+}
+
+// files lists the entries already on disk, used once to build the index. Only regular
+// files in the cache's own layout are listed; temporary files left behind by an
+// interrupted put are removed, and anything else is left alone.
+func (d *diskStore) files() []diskEntry {
+	shards, _ := os.ReadDir(d.dir)
+	var files []diskEntry
+	for _, shard := range shards {
+		if !shard.IsDir() || !diskShardPattern.MatchString(shard.Name()) {
+			continue
+		}
+		dir := filepath.Join(d.dir, shard.Name())
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || !strings.HasPrefix(name, shard.Name()) {
+				continue
+			}
+			p := filepath.Join(dir, name)
+			switch {
+			case diskKeyPattern.MatchString(name):
+				if info, err := entry.Info(); err == nil {
+					files = append(files, diskEntry{path: p, size: info.Size(), modTime: info.ModTime()})
+				}
+			case diskTempPattern.MatchString(name):
+				os.Remove(p)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiskStoreOnlyTouchesItsOwnFiles(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	key := Key([]byte("image"), "")
+	entry := filepath.Join(dir, key[:2], key)
+	leftover := entry + ".tmp123456"
+	foreign := []string{
+		filepath.Join(dir, "config.yaml"),
+		filepath.Join(dir, "logs", "server.log"),
+		filepath.Join(dir, "engine", "PaddleOCR-json.exe"),
+		filepath.Join(dir, key[:2], "notes.txt"),
+		filepath.Join(dir, key[:2], key+".bak"),
+		filepath.Join(dir, "ff", key), // right name, wrong shard
+		filepath.Join(dir, key[:2], "sub", key),
+	}
+	writeFile(t, entry, 10, old)
+	writeFile(t, leftover, 10, old)
+	for _, p := range foreign {
+		writeFile(t, p, 100, old)
+	}
+
+	// every file is old and over the size cap, yet only the cache's own entry is pruned
+	c, err := New(Options{Dir: dir, TTL: time.Minute, DiskMaxBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(entry); !os.IsNotExist(err) {
+		t.Errorf("expired entry not pruned: %v", err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("leftover temporary file not removed: %v", err)
+	}
+	for _, p := range foreign {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("foreign file %s removed: %v", p, err)
+		}
+	}
+	if got := c.Stats().DiskBytes; got != 0 {
+		t.Errorf("disk bytes = %d, want 0", got)
+	}
+}
+
+func TestDiskStoreReloadsEntries(t *testing.T) {
+	dir := t.TempDir()
+	key := Key([]byte("image"), "")
+	c, err := New(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put(key, []byte("result"))
+
+	c, err = New(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := c.Get(key); !ok || string(value) != "result" {
+		t.Errorf("Get after reopening = %q, %v", value, ok)
+	}
+	if got := c.Stats().DiskBytes; got != int64(len("result")) {
+		t.Errorf("disk bytes = %d, want %d", got, len("result"))
+	}
+
+	// keys outside the cache's own format never reach the file system
+	c.Put("../config.yaml", []byte("x"))
+	if _, err := os.Stat(filepath.Join(dir, "..", "../config.yaml")); !os.IsNotExist(err) {
+		t.Errorf("malformed key written to disk: %v", err)
+	}
+}
@@ -7,21 +7,28 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-playground/validator/v10"
 	"github.com/spf13/viper"
 	"github.com/suifei/ocr-server/internal/ocr"
 	"gopkg.in/yaml.v2"
 )
 
+// APIKeyPriority 为指定 API Key 的请求设置调度优先级
+type APIKeyPriority struct {
+	Key      string `mapstructure:"key" yaml:"key" validate:"required"`
+	Priority string `mapstructure:"priority" yaml:"priority" validate:"oneof=high normal bulk"`
+}
+
 type Config struct {
 	Addr             string        `mapstructure:"addr" yaml:"addr" validate:"required"`
 	Port             int           `mapstructure:"port" yaml:"port" validate:"required,min=1,max=65535"`
 	OCRExePath       string        `mapstructure:"ocr_exe_path" yaml:"ocr_exe_path"`
 	MinProcessors    int           `mapstructure:"min_processors" yaml:"min_processors" validate:"required,min=1"`
-	MaxProcessors    int           `mapstructure:"max_processors" yaml:"max_processors" validate:"required,min=1"`
+	MaxProcessors    int           `mapstructure:"max_processors" yaml:"max_processors" validate:"required,min=1,gtefield=MinProcessors"`
 	QueueSize        int           `mapstructure:"queue_size" yaml:"queue_size" validate:"required,min=1"`
-	ScaleThreshold   int64         `mapstructure:"scale_threshold" yaml:"scale_threshold" validate:"required,min=0"`
-	DegradeThreshold int64         `mapstructure:"degrade_threshold" yaml:"degrade_threshold" validate:"required,min=0"`
+	ScaleThreshold   int64         `mapstructure:"scale_threshold" yaml:"scale_threshold" validate:"required,min=0,max=100"`
+	DegradeThreshold int64         `mapstructure:"degrade_threshold" yaml:"degrade_threshold" validate:"required,min=0,ltfield=ScaleThreshold"`
 	IdleTimeout      time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout" validate:"required"`
 	WarmUpCount      int           `mapstructure:"warm_up_count" yaml:"warm_up_count" validate:"required,min=0"`
 	ShutdownTimeout  time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout" validate:"required"`
@@ -30,8 +37,53 @@ type Config struct {
 	LogMaxBackups    int           `mapstructure:"log_max_backups" yaml:"log_max_backups" validate:"required,min=0"`
 	LogMaxAge        int           `mapstructure:"log_max_age" yaml:"log_max_age" validate:"required,min=1"`
 	LogCompress      bool          `mapstructure:"log_compress" yaml:"log_compress"`
+	LogLevel         string        `mapstructure:"log_level" yaml:"log_level" validate:"omitempty,oneof=info warning error"`
+	LogFormat        string        `mapstructure:"log_format" yaml:"log_format" validate:"omitempty,oneof=text json"`
 	ThresholdMode    int           `mapstructure:"threshold_mode" yaml:"threshold_mode"`
 	ThresholdValue   int           `mapstructure:"threshold_value" yaml:"threshold_value" validate:"required,min=0,max=255"`
+	MaxImageWidth    int           `mapstructure:"max_image_width" yaml:"max_image_width" validate:"min=0"`
+	MaxImageHeight   int           `mapstructure:"max_image_height" yaml:"max_image_height" validate:"min=0"`
+	MaxImagePixels   int64         `mapstructure:"max_image_pixels" yaml:"max_image_pixels" validate:"min=0"`
+	MaxBodyBytes     int64         `mapstructure:"max_body_bytes" yaml:"max_body_bytes" validate:"min=0"`
+	MaxImageBytes    int64         `mapstructure:"max_image_bytes" yaml:"max_image_bytes" validate:"min=0"`
+	AllowedImageDirs []string      `mapstructure:"allowed_image_dirs" yaml:"allowed_image_dirs"`
+	DisableImagePath bool          `mapstructure:"disable_image_path" yaml:"disable_image_path"`
+	AllowedURLHosts  []string      `mapstructure:"allowed_url_hosts" yaml:"allowed_url_hosts"`
+	URLFetchTimeout  time.Duration `mapstructure:"url_fetch_timeout" yaml:"url_fetch_timeout" validate:"min=0"`
+	URLMaxRedirects  int           `mapstructure:"url_max_redirects" yaml:"url_max_redirects" validate:"min=0"`
+
+	AutoscaleInterval  time.Duration `mapstructure:"autoscale_interval" yaml:"autoscale_interval" validate:"min=0"`
+	ScaleUpCooldown    time.Duration `mapstructure:"scale_up_cooldown" yaml:"scale_up_cooldown" validate:"min=0"`
+	ScaleDownCooldown  time.Duration `mapstructure:"scale_down_cooldown" yaml:"scale_down_cooldown" validate:"min=0"`
+	ScaleWaitThreshold time.Duration `mapstructure:"scale_wait_threshold" yaml:"scale_wait_threshold" validate:"min=0"`
+
+	PriorityWeightHigh   int              `mapstructure:"priority_weight_high" yaml:"priority_weight_high" validate:"min=0"`
+	PriorityWeightNormal int              `mapstructure:"priority_weight_normal" yaml:"priority_weight_normal" validate:"min=0"`
+	PriorityWeightBulk   int              `mapstructure:"priority_weight_bulk" yaml:"priority_weight_bulk" validate:"min=0"`
+	APIKeyPriorities     []APIKeyPriority `mapstructure:"api_key_priorities" yaml:"api_key_priorities" validate:"dive"`
+
+	EnqueueWait  time.Duration `mapstructure:"enqueue_wait" yaml:"enqueue_wait" validate:"min=0"`
+	AdmissionSLO bool          `mapstructure:"admission_slo" yaml:"admission_slo"`
+
+	MaxJobsPerProcessor int64         `mapstructure:"max_jobs_per_processor" yaml:"max_jobs_per_processor" validate:"min=0"`
+	MaxProcessorAge     time.Duration `mapstructure:"max_processor_age" yaml:"max_processor_age" validate:"min=0"`
+	MaxRSSMB            int           `mapstructure:"max_rss_mb" yaml:"max_rss_mb" validate:"min=0"`
+
+	BreakerThreshold int           `mapstructure:"breaker_threshold" yaml:"breaker_threshold" validate:"min=0"`
+	BreakerCooldown  time.Duration `mapstructure:"breaker_cooldown" yaml:"breaker_cooldown" validate:"required_with=BreakerThreshold,min=0"`
+
+	AdminToken string `mapstructure:"admin_token" yaml:"admin_token"`
+
+	CacheMaxEntries   int           `mapstructure:"cache_max_entries" yaml:"cache_max_entries" validate:"min=0"`
+	CacheMaxBytes     int64         `mapstructure:"cache_max_bytes" yaml:"cache_max_bytes" validate:"min=0"`
+	CacheTTL          time.Duration `mapstructure:"cache_ttl" yaml:"cache_ttl" validate:"min=0"`
+	CacheDir          string        `mapstructure:"cache_dir" yaml:"cache_dir"`
+	CacheDiskMaxBytes int64         `mapstructure:"cache_disk_max_bytes" yaml:"cache_disk_max_bytes" validate:"min=0"`
+
+	TraceExporter    string  `mapstructure:"trace_exporter" yaml:"trace_exporter" validate:"omitempty,oneof=stdout file otlp"`
+	TraceFile        string  `mapstructure:"trace_file" yaml:"trace_file" validate:"required_if=TraceExporter file"`
+	TraceEndpoint    string  `mapstructure:"trace_endpoint" yaml:"trace_endpoint" validate:"required_if=TraceExporter otlp"`
+	TraceSampleRatio float64 `mapstructure:"trace_sample_ratio" yaml:"trace_sample_ratio" validate:"min=0,max=1"`
 }
 
 func LoadConfig() (Config, error) {
@@ -58,7 +110,7 @@ func setDefaults(cfg *Config) {
 	cfg.Port = 1111
 	cfg.OCRExePath = ocr.GetOCREnginePath()
 	cfg.MinProcessors = 4
-	cfg.MaxProcessors = runtime.NumCPU()
+	cfg.MaxProcessors = max(runtime.NumCPU(), cfg.MinProcessors)
 	cfg.QueueSize = 100
 	cfg.ScaleThreshold = 75
 	cfg.DegradeThreshold = 25
@@ -70,8 +122,84 @@ func setDefaults(cfg *Config) {
 	cfg.LogMaxBackups = 3
 	cfg.LogMaxAge = 28
 	cfg.LogCompress = false
+	cfg.LogLevel = "info"
+	cfg.LogFormat = "text"
 	cfg.ThresholdMode = 0
 	cfg.ThresholdValue = 100
+	cfg.MaxImageWidth = 16384
+	cfg.MaxImageHeight = 16384
+	cfg.MaxImagePixels = 50_000_000
+	cfg.MaxBodyBytes = 32 << 20
+	cfg.MaxImageBytes = 20 << 20
+	cfg.URLFetchTimeout = 10 * time.Second
+	cfg.URLMaxRedirects = 3
+	cfg.AutoscaleInterval = 5 * time.Second
+	cfg.ScaleUpCooldown = 30 * time.Second
+	cfg.ScaleDownCooldown = 2 * time.Minute
+	cfg.ScaleWaitThreshold = 2 * time.Second
+	cfg.PriorityWeightHigh = 6
+	cfg.PriorityWeightNormal = 3
+	cfg.PriorityWeightBulk = 1
+	cfg.EnqueueWait = 10 * time.Second
+	cfg.AdmissionSLO = false
+	// PaddleOCR-json 有内存泄漏，默认与 paddleocr 库原先的定时重启一样每 20 分钟回收一次
+	cfg.MaxProcessorAge = 20 * time.Minute
+	cfg.BreakerThreshold = 5
+	cfg.BreakerCooldown = 30 * time.Second
+	cfg.CacheMaxEntries = 1000
+	cfg.CacheMaxBytes = 64 << 20
+	cfg.CacheTTL = time.Hour
+	cfg.CacheDiskMaxBytes = 512 << 20
+	cfg.TraceFile = "ocr_traces.jsonl"
+	cfg.TraceEndpoint = "http://localhost:4318"
+	cfg.TraceSampleRatio = 1
+}
+
+// Reload 重新读取配置文件，未在文件中出现的字段使用默认值
+func Reload() (Config, error) {
+	var cfg Config
+	setDefaults(&cfg)
+
+	if err := readConfigFile(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// WatchConfig 监听配置文件变化，文件被写入或替换时调用 onChange。
+// 这里不使用 viper.WatchConfig：它会在自己的协程中调用 ReadInConfig，
+// 与 Reload 的读取产生数据竞争，因此只发出通知，由调用方统一读取配置
+func WatchConfig(onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建配置文件监听器错误: %w", err)
+	}
+
+	configFile := filepath.Clean(getConfigFilePath())
+	// 监听所在目录，编辑器先写临时文件再重命名的保存方式也能被发现
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("监听配置目录错误: %w", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					onChange()
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return nil
 }
 
 func generateDefaultConfig(cfg Config) error {
@@ -0,0 +1,186 @@
+package imgproc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+)
+
+// AnnotationBox describes a detected text region to be drawn on an image
+type AnnotationBox struct {
+	Points []image.Point
+	Score  float32
+}
+
+// AnnotateOptions controls how detection boxes are rendered
+type AnnotateOptions struct {
+	// Numbered draws the 1-based index of each box next to its first point
+	Numbered bool
+	// ColorByScore colors each box according to its confidence score
+	ColorByScore bool
+	// LineWidth is the outline thickness in pixels, defaults to 2
+	LineWidth int
+}
+
+var (
+	defaultBoxColor = color.RGBA{R: 0, G: 120, B: 255, A: 255}
+	highScoreColor  = color.RGBA{R: 0, G: 180, B: 0, A: 255}
+	midScoreColor   = color.RGBA{R: 255, G: 160, B: 0, A: 255}
+	lowScoreColor   = color.RGBA{R: 220, G: 0, B: 0, A: 255}
+	labelTextColor  = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+)
+
+// digitGlyphs is a 3x5 bitmap font for the digits 0-9, one row per entry
+var digitGlyphs = [10][5]uint8{
+	{0b111, 0b101, 0b101, 0b101, 0b111},
+	{0b010, 0b110, 0b010, 0b010, 0b111},
+	{0b111, 0b001, 0b111, 0b100, 0b111},
+	{0b111, 0b001, 0b111, 0b001, 0b111},
+	{0b101, 0b101, 0b111, 0b001, 0b001},
+	{0b111, 0b100, 0b111, 0b001, 0b111},
+	{0b111, 0b100, 0b111, 0b101, 0b111},
+	{0b111, 0b001, 0b010, 0b010, 0b010},
+	{0b111, 0b101, 0b111, 0b101, 0b111},
+	{0b111, 0b101, 0b111, 0b001, 0b111},
+}
+
+// ScoreColor returns the outline color for a confidence score
+func ScoreColor(score float32) color.RGBA {
+	switch {
+	case score >= 0.9:
+		return highScoreColor
+	case score >= 0.7:
+		return midScoreColor
+	default:
+		return lowScoreColor
+	}
+}
+
+// Annotate draws the given boxes onto a copy of img
+func Annotate(img image.Image, boxes []AnnotationBox, opts AnnotateOptions) *image.RGBA {
+	bounds := img.Bounds()
+	canvas := image.NewRGBA(bounds)
+	draw.Draw(canvas, bounds, img, bounds.Min, draw.Src)
+
+	lineWidth := opts.LineWidth
+	if lineWidth <= 0 {
+		lineWidth = 2
+	}
+
+	for i, box := range boxes {
+		if len(box.Points) == 0 {
+			continue
+		}
+
+		c := defaultBoxColor
+		if opts.ColorByScore {
+			c = ScoreColor(box.Score)
+		}
+
+		for j := range box.Points {
+			next := box.Points[(j+1)%len(box.Points)]
+			drawLine(canvas, box.Points[j], next, lineWidth, c)
+		}
+
+		if opts.Numbered {
+			drawLabel(canvas, box.Points[0], strconv.Itoa(i+1), c)
+		}
+	}
+
+	return canvas
+}
+
+// ImageToPNGBytes converts any image to PNG format byte slice
+func ImageToPNGBytes(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLine draws a line of the given width using Bresenham's algorithm
+func drawLine(img *image.RGBA, p0, p1 image.Point, width int, c color.RGBA) {
+	dx := abs(p1.X - p0.X)
+	dy := -abs(p1.Y - p0.Y)
+	sx, sy := 1, 1
+	if p0.X > p1.X {
+		sx = -1
+	}
+	if p0.Y > p1.Y {
+		sy = -1
+	}
+	e := dx + dy
+	x, y := p0.X, p0.Y
+	half := width / 2
+
+	for {
+		fillRect(img, image.Rect(x-half, y-half, x-half+width, y-half+width), c)
+		if x == p1.X && y == p1.Y {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
+		}
+	}
+}
+
+// drawLabel draws text made of digits on a filled background just above anchor
+func drawLabel(img *image.RGBA, anchor image.Point, text string, bg color.RGBA) {
+	const scale = 2
+	const padding = 2
+	glyphWidth := 3 * scale
+	glyphHeight := 5 * scale
+	width := len(text)*(glyphWidth+scale) - scale + 2*padding
+	height := glyphHeight + 2*padding
+
+	origin := image.Pt(anchor.X, anchor.Y-height)
+	if origin.Y < img.Bounds().Min.Y {
+		origin.Y = anchor.Y
+	}
+	fillRect(img, image.Rect(origin.X, origin.Y, origin.X+width, origin.Y+height), bg)
+
+	x := origin.X + padding
+	for _, ch := range text {
+		if ch < '0' || ch > '9' {
+			x += glyphWidth + scale
+			continue
+		}
+		glyph := digitGlyphs[ch-'0']
+		for row := 0; row < 5; row++ {
+			for col := 0; col < 3; col++ {
+				if glyph[row]&(1<<(2-col)) == 0 {
+					continue
+				}
+				px := x + col*scale
+				py := origin.Y + padding + row*scale
+				fillRect(img, image.Rect(px, py, px+scale, py+scale), labelTextColor)
+			}
+		}
+		x += glyphWidth + scale
+	}
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+	draw.Draw(img, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,56 @@
+package imgproc
+
+import (
+	"bytes"
+	"image"
+)
+
+// Image formats recognized by SniffFormat
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+	FormatBMP  = "bmp"
+	FormatTIFF = "tiff"
+	FormatWEBP = "webp"
+)
+
+var formatSignatures = []struct {
+	format string
+	magic  []byte
+}{
+	{FormatPNG, []byte("\x89PNG\r\n\x1a\n")},
+	{FormatJPEG, []byte("\xff\xd8\xff")},
+	{FormatGIF, []byte("GIF87a")},
+	{FormatGIF, []byte("GIF89a")},
+	{FormatBMP, []byte("BM")},
+	{FormatTIFF, []byte("II*\x00")},
+	{FormatTIFF, []byte("MM\x00*")},
+}
+
+// SniffFormat detects the image format from its magic bytes, returning "" if unknown
+func SniffFormat(data []byte) string {
+	for _, sig := range formatSignatures {
+		if bytes.HasPrefix(data, sig.magic) {
+			return sig.format
+		}
+	}
+	if len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) {
+		return FormatWEBP
+	}
+	return ""
+}
+
+// IsDecodable reports whether images of the given format can be decoded for preprocessing
+func IsDecodable(format string) bool {
+	switch format {
+	case FormatPNG, FormatJPEG, FormatGIF:
+		return true
+	}
+	return false
+}
+
+// DecodeConfig reads the image dimensions and format without decoding the pixel data
+func DecodeConfig(data []byte) (image.Config, string, error) {
+	return image.DecodeConfig(bytes.NewReader(data))
+}
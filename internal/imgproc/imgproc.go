@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"image"
 	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
 	"image/png"
 )
 
@@ -0,0 +1,87 @@
+package imgproc
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// ProbeText is the text rendered into the health check probe image
+const ProbeText = "HEALTH CHECK"
+
+const (
+	probeScale   = 8 // size in pixels of one glyph cell
+	probeMargin  = 4 // margin around the text, in glyph cells
+	probeSpacing = 1 // gap between letters, in glyph cells
+	probeSpace   = 4 // width of a space, in glyph cells
+)
+
+// probeGlyphs is a 5x7 bitmap font covering the letters of ProbeText,
+// one row per entry with the leftmost pixel in bit 4
+var probeGlyphs = map[rune][7]uint8{
+	'A': {0x0E, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'C': {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'E': {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'H': {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'K': {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L': {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'T': {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+}
+
+// ProbeImage renders ProbeText as black text on a white background and returns it as PNG
+func ProbeImage() ([]byte, error) {
+	width := 0
+	for _, ch := range ProbeText {
+		if ch == ' ' {
+			width += probeSpace
+		} else {
+			width += 5 + probeSpacing
+		}
+	}
+	width += 2*probeMargin - probeSpacing
+	height := 7 + 2*probeMargin
+
+	img := image.NewGray(image.Rect(0, 0, width*probeScale, height*probeScale))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+
+	x := probeMargin
+	for _, ch := range ProbeText {
+		glyph, ok := probeGlyphs[ch]
+		if !ok {
+			x += probeSpace
+			continue
+		}
+		for row, bits := range glyph {
+			for col := 0; col < 5; col++ {
+				if bits&(1<<(4-col)) == 0 {
+					continue
+				}
+				cell := image.Rect(x+col, probeMargin+row, x+col+1, probeMargin+row+1)
+				fillGray(img, image.Rectangle{Min: cell.Min.Mul(probeScale), Max: cell.Max.Mul(probeScale)}, color.Gray{Y: 0})
+			}
+		}
+		x += 5 + probeSpacing
+	}
+
+	return ImageToPNGBytes(img)
+}
+
+// MatchesProbeText reports whether the recognized text lines contain ProbeText,
+// ignoring case and whitespace
+func MatchesProbeText(lines []string) bool {
+	normalize := func(s string) string {
+		return strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	}
+	return strings.Contains(normalize(strings.Join(lines, "")), normalize(ProbeText))
+}
+
+func fillGray(img *image.Gray, r image.Rectangle, c color.Gray) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetGray(x, y, c)
+		}
+	}
+}
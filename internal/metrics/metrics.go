@@ -0,0 +1,258 @@
+// Package metrics implements the small subset of the Prometheus text exposition
+// format needed by the server: counters, gauges and histograms, optionally labelled.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics in registration order and renders them as text
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText renders all metrics in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ContentType is the Content-Type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w *bufio.Writer, name string, labels []string, values []string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, escapeLabel(values[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter identified by the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter identified by the label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", c.name, len(c.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cv := c.values[k]
+		writeSample(w, c.name, c.labels, cv.labels, cv.value)
+	}
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; the last entry is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, which must be sorted
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe records a single value
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		writeSample(w, h.name+"_bucket", []string{"le"}, []string{formatFloat(upper)}, float64(cumulative))
+	}
+	writeSample(w, h.name+"_bucket", []string{"le"}, []string{"+Inf"}, float64(h.count))
+	writeSample(w, h.name+"_sum", nil, nil, h.sum)
+	writeSample(w, h.name+"_count", nil, nil, float64(h.count))
+}
+
+// funcMetric reads its values at scrape time
+type funcMetric struct {
+	name  string
+	help  string
+	typ   string
+	label string
+	fn    func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn at scrape time
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: "gauge", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge with a single label whose values are read from fn at scrape time
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&funcMetric{name: name, help: help, typ: "gauge", label: label, fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is read from fn at scrape time
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{name: name, help: help, typ: "counter", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	values := f.fn()
+	writeHeader(w, f.name, f.help, f.typ)
+	if f.label == "" {
+		writeSample(w, f.name, nil, nil, values[""])
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeSample(w, f.name, []string{f.label}, []string{k}, values[k])
+	}
+}
+
+// ExponentialBuckets returns count upper bounds starting at start, each factor times the previous
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sample is one parsed line of the text exposition format
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseText parses the text exposition format strictly enough to catch malformed
+// output: every sample must belong to a family announced by a preceding TYPE line,
+// and label values must use only the escapes the format defines.
+func parseText(t *testing.T, text string) (samples []sample, types map[string]string) {
+	t.Helper()
+	types = make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if line == "" {
+			t.Fatalf("line %d: empty line", n)
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+				t.Fatalf("line %d: malformed comment %q", n, line)
+			}
+			if fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			t.Fatalf("line %d: %v: %q", n, err, line)
+		}
+		if familyOf(s.name, types) == "" {
+			t.Fatalf("line %d: sample %s has no TYPE line", n, s.name)
+		}
+		samples = append(samples, s)
+	}
+	return samples, types
+}
+
+func parseSample(line string) (sample, error) {
+	s := sample{labels: make(map[string]string)}
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		return s, fmt.Errorf("missing metric name")
+	}
+	s.name, line = line[:i], line[i:]
+
+	if line[0] == '{' {
+		line = line[1:]
+		for line[0] != '}' {
+			eq := strings.Index(line, `="`)
+			if eq <= 0 {
+				return s, fmt.Errorf("malformed label")
+			}
+			name := line[:eq]
+			line = line[eq+2:]
+			var value strings.Builder
+			for {
+				if line == "" {
+					return s, fmt.Errorf("unterminated label value")
+				}
+				c := line[0]
+				line = line[1:]
+				if c == '"' {
+					break
+				}
+				if c == '\n' {
+					return s, fmt.Errorf("raw newline in label value")
+				}
+				if c == '\\' {
+					if line == "" {
+						return s, fmt.Errorf("dangling escape")
+					}
+					switch line[0] {
+					case '\\', '"':
+						value.WriteByte(line[0])
+					case 'n':
+						value.WriteByte('\n')
+					default:
+						return s, fmt.Errorf("unknown escape \\%c", line[0])
+					}
+					line = line[1:]
+					continue
+				}
+				value.WriteByte(c)
+			}
+			if _, dup := s.labels[name]; dup {
+				return s, fmt.Errorf("duplicate label %s", name)
+			}
+			s.labels[name] = value.String()
+			if strings.HasPrefix(line, ",") {
+				line = line[1:]
+			} else if !strings.HasPrefix(line, "}") {
+				return s, fmt.Errorf("expected , or } after label %s", name)
+			}
+		}
+		line = line[1:]
+	}
+
+	if !strings.HasPrefix(line, " ") {
+		return s, fmt.Errorf("missing value")
+	}
+	v, err := strconv.ParseFloat(line[1:], 64)
+	if err != nil {
+		return s, err
+	}
+	s.value = v
+	return s, nil
+}
+
+// familyOf returns the family type of a sample name, accounting for histogram suffixes
+func familyOf(name string, types map[string]string) string {
+	if typ, ok := types[name]; ok {
+		return typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok && types[base] == "histogram" {
+			return "histogram"
+		}
+	}
+	return ""
+}
+
+func scrape(t *testing.T, r *Registry) ([]sample, map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return parseText(t, buf.String())
+}
+
+func find(samples []sample, name string, labels map[string]string) (float64, bool) {
+next:
+	for _, s := range samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+		for k, v := range labels {
+			if s.labels[k] != v {
+				continue next
+			}
+		}
+		return s.value, true
+	}
+	return 0, false
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 0.5, 1})
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2, 5} {
+		h.Observe(v)
+	}
+
+	samples, types := scrape(t, r)
+	if types["latency_seconds"] != "histogram" {
+		t.Fatalf("TYPE = %q, want histogram", types["latency_seconds"])
+	}
+
+	// buckets are cumulative and an observation equal to a bound falls in that bucket
+	want := []struct {
+		le    string
+		count float64
+	}{{"0.1", 2}, {"0.5", 3}, {"1", 4}, {"+Inf", 6}}
+	var prev float64
+	for _, w := range want {
+		got, ok := find(samples, "latency_seconds_bucket", map[string]string{"le": w.le})
+		if !ok {
+			t.Fatalf("missing bucket le=%q", w.le)
+		}
+		if got != w.count {
+			t.Errorf("bucket le=%q = %v, want %v", w.le, got, w.count)
+		}
+		if got < prev {
+			t.Errorf("bucket le=%q = %v is below the previous bucket %v", w.le, got, prev)
+		}
+		prev = got
+	}
+
+	count, _ := find(samples, "latency_seconds_count", nil)
+	inf, _ := find(samples, "latency_seconds_bucket", map[string]string{"le": "+Inf"})
+	if count != 6 || inf != count {
+		t.Errorf("count = %v, +Inf bucket = %v, want both 6", count, inf)
+	}
+	sum, _ := find(samples, "latency_seconds_sum", nil)
+	if math.Abs(sum-8.15) > 1e-9 {
+		t.Errorf("sum = %v, want 8.15", sum)
+	}
+}
+
+func TestEmptyHistogram(t *testing.T) {
+	r := NewRegistry()
+	r.NewHistogram("empty", "Nothing observed.", ExponentialBuckets(1, 2, 3))
+
+	samples, _ := scrape(t, r)
+	for _, le := range []string{"1", "2", "4", "+Inf"} {
+		if v, ok := find(samples, "empty_bucket", map[string]string{"le": le}); !ok || v != 0 {
+			t.Errorf("bucket le=%q = %v, %v, want 0", le, v, ok)
+		}
+	}
+	if v, ok := find(samples, "empty_count", nil); !ok || v != 0 {
+		t.Errorf("count = %v, %v, want 0", v, ok)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("errors_total", "Errors by \"reason\".\nSecond line with a \\.", "reason", "path")
+	values := []string{
+		`say "hi"`,
+		`C:\temp\`,
+		"line one\nline two",
+		`mixed \"quoted\" \n literal`,
+		"",
+	}
+	for i, v := range values {
+		c.Add(float64(i+1), v, "/ocr")
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	// every sample and comment must stay on one line
+	if n := strings.Count(text, "\n"); n != 2+len(values) {
+		t.Fatalf("output has %d lines, want %d:\n%s", n, 2+len(values), text)
+	}
+	if !strings.Contains(text, `# HELP errors_total Errors by "reason".\nSecond line with a \\.`) {
+		t.Errorf("HELP not escaped:\n%s", text)
+	}
+
+	samples, _ := parseText(t, text)
+	for i, v := range values {
+		got, ok := find(samples, "errors_total", map[string]string{"reason": v, "path": "/ocr"})
+		if !ok {
+			t.Errorf("label value %q did not round-trip:\n%s", v, text)
+			continue
+		}
+		if got != float64(i+1) {
+			t.Errorf("errors_total{reason=%q} = %v, want %d", v, got, i+1)
+		}
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "outcome", "code")
+	c.Inc("success", "none")
+	c.Inc("error", "TIMEOUT")
+	c.Inc("success", "none")
+	c.Add(0.5, "error", "TIMEOUT")
+
+	samples, types := scrape(t, r)
+	if types["requests_total"] != "counter" {
+		t.Fatalf("TYPE = %q, want counter", types["requests_total"])
+	}
+	if v, _ := find(samples, "requests_total", map[string]string{"outcome": "success", "code": "none"}); v != 2 {
+		t.Errorf("success = %v, want 2", v)
+	}
+	if v, _ := find(samples, "requests_total", map[string]string{"outcome": "error", "code": "TIMEOUT"}); v != 1.5 {
+		t.Errorf("error = %v, want 1.5", v)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with the wrong number of label values did not panic")
+		}
+	}()
+	c.Inc("success")
+}
+
+func TestFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("temperature", "Current temperature.", func() float64 { return math.Inf(1) })
+	r.NewCounterFunc("ticks_total", "Ticks.", func() float64 { return 42 })
+	r.NewGaugeVecFunc("processors", "Processors by state.", "state", func() map[string]float64 {
+		return map[string]float64{"idle": 2, "in_use": 1}
+	})
+
+	samples, types := scrape(t, r)
+	if types["temperature"] != "gauge" || types["ticks_total"] != "counter" || types["processors"] != "gauge" {
+		t.Errorf("types = %v", types)
+	}
+	if v, _ := find(samples, "temperature", nil); !math.IsInf(v, 1) {
+		t.Errorf("temperature = %v, want +Inf", v)
+	}
+	if v, _ := find(samples, "ticks_total", nil); v != 42 {
+		t.Errorf("ticks_total = %v, want 42", v)
+	}
+	if v, _ := find(samples, "processors", map[string]string{"state": "in_use"}); v != 1 {
+		t.Errorf("processors{state=in_use} = %v, want 1", v)
+	}
+}
@@ -127,7 +127,7 @@ func extractArchive() error {
 	_, err = a.Extract(resDir)
 
 	if err != nil {
-		fmt.Println("提取失败: %v\n", err)
+		fmt.Printf("提取失败: %v\n", err)
 	}
 
 	go func() {
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+// registerAdminRoutes 注册管理接口，所有接口都需要 admin_token 认证
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/processors", s.requireAdmin(s.handleListProcessors))
+	mux.HandleFunc("POST /admin/processors/{id}/restart", s.requireAdmin(s.handleRestartProcessor))
+	mux.HandleFunc("PUT /admin/pool", s.requireAdmin(s.handleSetPoolBounds))
+	mux.HandleFunc("POST /admin/healthcheck", s.requireAdmin(s.handleAdminHealthCheck))
+}
+
+// requireAdmin 校验 Authorization: Bearer <admin_token>，未配置 admin_token 时管理接口不可用
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.cfg().AdminToken
+		if token == "" {
+			writeError(w, newAPIError(ErrCodeNotFound, http.StatusNotFound, "管理接口未启用"))
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			utils.LogWarning("审计：拒绝未认证的管理请求，客户端=%s 路径=%s", r.RemoteAddr, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ocr-server"`)
+			writeError(w, newAPIError(ErrCodeUnauthorized, http.StatusUnauthorized, "管理接口需要有效的令牌"))
+			return
+		}
+
+		utils.LogInfo("审计：管理请求 %s %s，客户端=%s", r.Method, r.URL.Path, r.RemoteAddr)
+		next(w, r)
+	}
+}
+
+// handleListProcessors 列出所有处理器及其状态
+func (s *Server) handleListProcessors(w http.ResponseWriter, r *http.Request) {
+	snap := s.pool.Snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"min_processors": snap.Min,
+		"max_processors": snap.Max,
+		"creating":       snap.Creating,
+		"processors":     s.pool.Describe(),
+	})
+}
+
+// handleRestartProcessor 强制重启指定处理器：空闲的处理器立即关闭并补充新的处理器，
+// 正在使用的处理器在当前任务完成后重启
+func (s *Server) handleRestartProcessor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "无效的处理器 ID: %s", r.PathValue("id")))
+		return
+	}
+
+	processor := s.pool.Find(id)
+	if processor == nil {
+		writeError(w, newAPIError(ErrCodeNotFound, http.StatusNotFound, "处理器 %d 不存在", id))
+		return
+	}
+
+	if s.pool.RequestRestart(processor) {
+		utils.LogInfo("处理器 %d 正在使用，将在任务完成后重启", id)
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"id": id, "status": "restart_pending"})
+		return
+	}
+	if !s.pool.checkout(processor) {
+		// 处理器刚被其他请求取走，改为归还时重启
+		if s.pool.RequestRestart(processor) {
+			writeJSON(w, http.StatusAccepted, map[string]interface{}{"id": id, "status": "restart_pending"})
+			return
+		}
+		writeError(w, newAPIError(ErrCodeNotFound, http.StatusNotFound, "处理器 %d 已退役", id))
+		return
+	}
+
+	s.recycleProcessor(processor, "管理员请求重启")
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "status": "restarted"})
+}
+
+// poolBoundsRequest 是调整处理器数量上下限的请求
+type poolBoundsRequest struct {
+	MinProcessors *int `json:"min_processors"`
+	MaxProcessors *int `json:"max_processors"`
+}
+
+// handleSetPoolBounds 在运行时调整处理器数量的上下限，修改会写入当前配置但不会保存到配置文件
+func (s *Server) handleSetPoolBounds(w http.ResponseWriter, r *http.Request) {
+	var req poolBoundsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "解析请求失败: %v", err))
+		return
+	}
+
+	// 与热加载共用锁，避免两者同时替换配置
+	s.reload.mu.Lock()
+	defer s.reload.mu.Unlock()
+
+	cfg := *s.cfg()
+	if req.MinProcessors != nil {
+		cfg.MinProcessors = *req.MinProcessors
+	}
+	if req.MaxProcessors != nil {
+		cfg.MaxProcessors = *req.MaxProcessors
+	}
+	if cfg.MinProcessors < 1 || cfg.MaxProcessors < cfg.MinProcessors {
+		writeError(w, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest,
+			"无效的处理器数量范围: min=%d max=%d", cfg.MinProcessors, cfg.MaxProcessors))
+		return
+	}
+
+	s.config.Store(&cfg)
+	s.pool.SetBounds(cfg.MinProcessors, cfg.MaxProcessors)
+	utils.LogInfo("管理员将处理器数量范围调整为 %d-%d", cfg.MinProcessors, cfg.MaxProcessors)
+
+	// 立即补足到新的下限，不必等待下一次定期预热
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.PrewarmProcessors()
+	}()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"min_processors": cfg.MinProcessors,
+		"max_processors": cfg.MaxProcessors,
+	})
+}
+
+// handleAdminHealthCheck 立即对所有空闲处理器执行健康检查并返回结果
+func (s *Server) handleAdminHealthCheck(w http.ResponseWriter, r *http.Request) {
+	results := s.HealthCheck()
+	snap := s.pool.Snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results": results,
+		"total":   snap.Total,
+		"idle":    snap.Idle,
+		"in_use":  snap.InUse,
+		"breaker": s.breaker.State().String(),
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// estimateQueueWait 根据队列长度、最近的平均处理时间和处理器数量估算新任务的排队等待时间
+func (s *Server) estimateQueueWait() time.Duration {
+	avg := s.stats.Latency.RecentMean()
+	workers := s.pool.Size()
+	if workers < 1 {
+		workers = 1
+	}
+	return time.Duration(int64(s.queue.Len()) * int64(avg) / int64(workers))
+}
+
+// retryAfterSeconds 将等待时间转换为 Retry-After 秒数，至少为 1 秒
+func retryAfterSeconds(wait time.Duration) int {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// rejectBusy 拒绝请求，并根据估算的等待时间设置 Retry-After
+func (s *Server) rejectBusy(w http.ResponseWriter, apiErr *apiError, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	writeError(w, apiErr)
+}
+
+// checkDeadlineAdmission 在 SLO 模式下判断任务能否在截止时间内完成，
+// 返回 nil 表示可以接受
+func (s *Server) checkDeadlineAdmission(task ocrTask, wait time.Duration) *apiError {
+	if !s.cfg().AdmissionSLO {
+		return nil
+	}
+	deadline, ok := task.Ctx.Deadline()
+	if !ok {
+		return nil
+	}
+
+	avg := s.stats.Latency.RecentMean()
+	if remaining := time.Until(deadline); wait+avg > remaining {
+		return newAPIError(ErrCodeDeadlineUnachievable, http.StatusTooManyRequests,
+			"预计等待 %.3fs 加处理 %.3fs 超过截止时间剩余的 %.3fs", wait.Seconds(), avg.Seconds(), remaining.Seconds())
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+const (
+	// 连续多少次采样满足条件才执行伸缩，避免负载抖动导致频繁扩缩
+	scaleUpSamples   = 2
+	scaleDownSamples = 3
+	// 保留的最近伸缩决策数量
+	maxScaleDecisions = 20
+)
+
+// scaleDecision 记录一次伸缩决策
+type scaleDecision struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	From        int       `json:"from"`
+	To          int       `json:"to"`
+	Reason      string    `json:"reason"`
+	Utilization float64   `json:"utilization"`
+	QueueDepth  int       `json:"queue_depth"`
+	AverageWait float64   `json:"average_wait"`
+}
+
+// autoscaler 根据队列深度、等待时间和处理器利用率调整处理器数量
+type autoscaler struct {
+	// 自上次采样以来的排队等待时间，由任务协程原子累加
+	waitTotal int64
+	waitCount int64
+	// 后台扩容进行中时为 true，此期间不再发起新的扩容
+	growing atomic.Bool
+
+	mu            sync.Mutex
+	upStreak      int
+	downStreak    int
+	lastScaleUp   time.Time
+	lastScaleDown time.Time
+	scaleUps      int64
+	scaleDowns    int64
+	decisions     []scaleDecision
+	lastSample    autoscaleSample
+}
+
+// autoscaleSample 是一次采样的负载指标
+type autoscaleSample struct {
+	Utilization float64
+	QueueDepth  int
+	AverageWait time.Duration
+}
+
+// observeWait 记录任务从入队到获得处理器的等待时间
+func (a *autoscaler) observeWait(d time.Duration) {
+	atomic.AddInt64(&a.waitTotal, int64(d))
+	atomic.AddInt64(&a.waitCount, 1)
+}
+
+// takeAverageWait 返回自上次调用以来的平均等待时间并清零
+func (a *autoscaler) takeAverageWait() time.Duration {
+	total := atomic.SwapInt64(&a.waitTotal, 0)
+	count := atomic.SwapInt64(&a.waitCount, 0)
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(total / count)
+}
+
+func (a *autoscaler) record(d scaleDecision) {
+	a.decisions = append(a.decisions, d)
+	if len(a.decisions) > maxScaleDecisions {
+		a.decisions = a.decisions[len(a.decisions)-maxScaleDecisions:]
+	}
+}
+
+// autoscale 采样负载并在需要时扩容或缩容，由 monitorProcessors 定期调用
+func (s *Server) autoscale() {
+	a := s.autoscaler
+	snap := s.pool.Snapshot()
+
+	sample := autoscaleSample{
+		QueueDepth:  s.queue.Len(),
+		AverageWait: a.takeAverageWait(),
+	}
+	if snap.Total > 0 {
+		sample.Utilization = float64(snap.InUse) / float64(snap.Total) * 100
+	} else {
+		sample.Utilization = 100
+	}
+
+	overloaded := (sample.Utilization >= float64(s.cfg().ScaleThreshold) && sample.QueueDepth > 0) ||
+		(s.cfg().ScaleWaitThreshold > 0 && sample.AverageWait >= s.cfg().ScaleWaitThreshold)
+	underloaded := sample.Utilization <= float64(s.cfg().DegradeThreshold) && sample.QueueDepth == 0
+
+	a.mu.Lock()
+	a.lastSample = sample
+	switch {
+	case overloaded:
+		a.upStreak++
+		a.downStreak = 0
+	case underloaded:
+		a.downStreak++
+		a.upStreak = 0
+	default:
+		a.upStreak = 0
+		a.downStreak = 0
+	}
+	now := time.Now()
+	scaleUp := a.upStreak >= scaleUpSamples && now.Sub(a.lastScaleUp) >= s.cfg().ScaleUpCooldown
+	scaleDown := a.downStreak >= scaleDownSamples && now.Sub(a.lastScaleDown) >= s.cfg().ScaleDownCooldown &&
+		now.Sub(a.lastScaleUp) >= s.cfg().ScaleDownCooldown
+	a.mu.Unlock()
+
+	switch {
+	case scaleUp:
+		if a.growing.Load() {
+			return
+		}
+		s.scaleUp(snap, sample)
+	case scaleDown:
+		s.scaleDown(snap, sample)
+	}
+}
+
+func (s *Server) scaleUp(snap poolSnapshot, sample autoscaleSample) {
+	room := snap.Max - snap.Total - snap.Creating
+	if room <= 0 {
+		return
+	}
+	step := sample.QueueDepth
+	if step < 1 {
+		step = 1
+	}
+	if step > room {
+		step = room
+	}
+
+	reason := "队列积压且利用率超过扩展阈值"
+	if sample.AverageWait >= s.cfg().ScaleWaitThreshold && s.cfg().ScaleWaitThreshold > 0 {
+		reason = "平均等待时间超过阈值"
+	}
+
+	// 启动引擎需要数秒，在后台扩容以免阻塞健康检查、熔断探测和空闲回收；
+	// 池的 creating 计数保证并发扩容不会超过上限
+	a := s.autoscaler
+	if !a.growing.CompareAndSwap(false, true) {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer a.growing.Store(false)
+
+		created, err := s.pool.Grow(step)
+		if err != nil {
+			utils.LogWarning("自动扩容失败：%v", err)
+		}
+		if created == 0 {
+			return
+		}
+
+		s.recordScaleDecision("up", snap.Total, snap.Total+created, reason, sample)
+	}()
+}
+
+func (s *Server) scaleDown(snap poolSnapshot, sample autoscaleSample) {
+	removed := s.pool.Shrink(1, s.cfg().WarmUpCount)
+	if removed == 0 {
+		return
+	}
+
+	s.recordScaleDecision("down", snap.Total, snap.Total-removed, "利用率低于降级阈值且无排队", sample)
+}
+
+func (s *Server) recordScaleDecision(action string, from, to int, reason string, sample autoscaleSample) {
+	a := s.autoscaler
+	d := scaleDecision{
+		Time:        time.Now(),
+		Action:      action,
+		From:        from,
+		To:          to,
+		Reason:      reason,
+		Utilization: sample.Utilization,
+		QueueDepth:  sample.QueueDepth,
+		AverageWait: sample.AverageWait.Seconds(),
+	}
+
+	a.mu.Lock()
+	if action == "up" {
+		a.lastScaleUp = d.Time
+		a.upStreak = 0
+		a.scaleUps++
+	} else {
+		a.lastScaleDown = d.Time
+		a.downStreak = 0
+		a.scaleDowns++
+	}
+	a.record(d)
+	a.mu.Unlock()
+
+	utils.LogInfo("自动伸缩：%s %d -> %d，原因：%s（利用率 %.1f%%，排队 %d，平均等待 %.3fs）",
+		action, from, to, reason, sample.Utilization, sample.QueueDepth, d.AverageWait)
+}
+
+// autoscalerStats 返回自动伸缩器的状态
+func (s *Server) autoscalerStats() map[string]interface{} {
+	a := s.autoscaler
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return map[string]interface{}{
+		"enabled":          s.cfg().AutoscaleInterval > 0,
+		"utilization":      a.lastSample.Utilization,
+		"queue_depth":      a.lastSample.QueueDepth,
+		"average_wait":     a.lastSample.AverageWait.Seconds(),
+		"scale_ups":        a.scaleUps,
+		"scale_downs":      a.scaleDowns,
+		"recent_decisions": append([]scaleDecision(nil), a.decisions...),
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/config"
+)
+
+func TestScaleUpDoesNotBlockMonitor(t *testing.T) {
+	s, err := NewServer(config.Config{MinProcessors: 1, MaxProcessors: 4, QueueSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFactory{delay: 200 * time.Millisecond}
+	s.pool = newProcessorPool(f.newEngine, 0, 4, true)
+	defer s.pool.Close()
+
+	// 引擎启动很慢时 scaleUp 仍立即返回，扩容进行中不会重复发起
+	sample := autoscaleSample{Utilization: 100, QueueDepth: 2}
+	start := time.Now()
+	s.scaleUp(s.pool.Snapshot(), sample)
+	s.scaleUp(s.pool.Snapshot(), sample)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("scaleUp blocked for %v", elapsed)
+	}
+
+	s.wg.Wait()
+	if snap := s.pool.Snapshot(); snap.Total != 2 || snap.Creating != 0 {
+		t.Errorf("after scale up: total %d, creating %d, want 2 and 0", snap.Total, snap.Creating)
+	}
+	if s.autoscaler.growing.Load() {
+		t.Error("growing flag still set after Grow finished")
+	}
+	stats := s.autoscalerStats()
+	if stats["scale_ups"] != int64(1) {
+		t.Errorf("scale_ups = %v, want 1", stats["scale_ups"])
+	}
+}
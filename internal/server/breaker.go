@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+var errCircuitOpen = errors.New("OCR 引擎连续失败，熔断器已打开")
+
+// errEngineAborted 表示引擎调用因 panic 未能完成，记为一次失败以释放半开状态的探测名额
+var errEngineAborted = errors.New("OCR 引擎调用异常中止")
+
+// breakerState 是熔断器的状态
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = [...]string{"closed", "open", "half_open"}
+
+func (st breakerState) String() string {
+	return breakerStateNames[st]
+}
+
+// circuitBreaker 保护引擎创建和识别调用：连续失败达到阈值后打开，
+// 在冷却期内直接拒绝调用；冷却结束后进入半开状态，只放行一个探测调用，
+// 探测成功则关闭，失败则重新打开
+type circuitBreaker struct {
+	threshold int // 为 0 时禁用熔断
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int  // 连续失败次数
+	probing   bool // 半开状态下是否已有探测调用在进行
+	openedAt  time.Time
+	opens     int64
+	rejected  int64
+	lastError string
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow 判断是否允许一次引擎调用；返回 nil 时调用方必须随后调用 Success、Started 或 Failure，
+// 调用方 panic 时也要调用 Failure，否则半开状态的探测名额不会释放
+func (b *circuitBreaker) Allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		utils.LogInfo("熔断器进入半开状态，放行探测调用")
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return errCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// Success 记录一次成功的引擎调用
+func (b *circuitBreaker) Success() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		utils.LogInfo("OCR 引擎探测成功，熔断器已关闭")
+	}
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Started 记录一次成功的引擎启动。启动成功不代表识别正常，因此不清零连续失败次数：
+// 半开探测成功后关闭熔断器，但识别再次失败会立即重新打开
+func (b *circuitBreaker) Started() {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		utils.LogInfo("OCR 引擎启动成功，熔断器已关闭")
+	}
+	b.state = breakerClosed
+	b.probing = false
+}
+
+// Failure 记录一次失败的引擎调用
+func (b *circuitBreaker) Failure(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+	b.probing = false
+
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.opens++
+		utils.LogWarning("OCR 引擎连续失败 %d 次，熔断器打开 %v：%v", b.failures, b.cooldown, err)
+	}
+}
+
+// RetryAfter 返回熔断器打开时距离允许探测的剩余时间，未打开时返回 false
+func (b *circuitBreaker) RetryAfter() (time.Duration, bool) {
+	if b.threshold <= 0 {
+		return 0, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return 0, false
+	}
+	remaining := b.cooldown - time.Since(b.openedAt)
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// State 返回熔断器当前状态
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stats 返回熔断器的状态信息
+func (b *circuitBreaker) Stats() map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"enabled":              b.threshold > 0,
+		"state":                b.state.String(),
+		"consecutive_failures": b.failures,
+		"opens":                b.opens,
+		"rejected":             b.rejected,
+		"last_error":           b.lastError,
+	}
+	if b.state == breakerOpen {
+		stats["opened_at"] = b.openedAt
+		if remaining := b.cooldown - time.Since(b.openedAt); remaining > 0 {
+			stats["retry_after"] = remaining.Seconds()
+		} else {
+			stats["retry_after"] = 0.0
+		}
+	}
+	return stats
+}
+
+// probeEngine 在熔断器冷却结束后启动一个临时引擎作为探测，
+// 使没有新请求时熔断器也能及时恢复
+func (s *Server) probeEngine() {
+	if s.breaker.State() != breakerOpen {
+		return
+	}
+	if _, open := s.breaker.RetryAfter(); open {
+		return
+	}
+
+	utils.LogInfo("熔断器冷却结束，探测 OCR 引擎")
+	engine, err := s.newOCREngine()
+	if err != nil {
+		utils.LogWarning("OCR 引擎探测失败：%v", err)
+		return
+	}
+	engine.Close()
+}
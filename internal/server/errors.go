@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode 是返回给客户端的稳定错误码
+type ErrorCode string
+
+const (
+	ErrCodeInvalidRequest       ErrorCode = "INVALID_REQUEST"
+	ErrCodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrCodeInvalidImage         ErrorCode = "INVALID_IMAGE"
+	ErrCodeUnsupportedFormat    ErrorCode = "UNSUPPORTED_FORMAT"
+	ErrCodeFileNotFound         ErrorCode = "FILE_NOT_FOUND"
+	ErrCodePathNotAllowed       ErrorCode = "PATH_NOT_ALLOWED"
+	ErrCodeImagePathDisabled    ErrorCode = "IMAGE_PATH_DISABLED"
+	ErrCodeURLNotAllowed        ErrorCode = "URL_NOT_ALLOWED"
+	ErrCodeImageURLDisabled     ErrorCode = "IMAGE_URL_DISABLED"
+	ErrCodeFetchFailed          ErrorCode = "FETCH_FAILED"
+	ErrCodeFetchRejected        ErrorCode = "FETCH_REJECTED"
+	ErrCodeImageTooLarge        ErrorCode = "IMAGE_TOO_LARGE"
+	ErrCodeRequestTooLarge      ErrorCode = "REQUEST_TOO_LARGE"
+	ErrCodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	ErrCodeNotFound             ErrorCode = "NOT_FOUND"
+	ErrCodeServerBusy           ErrorCode = "SERVER_BUSY"
+	ErrCodeDeadlineUnachievable ErrorCode = "DEADLINE_UNACHIEVABLE"
+	ErrCodeShuttingDown         ErrorCode = "SHUTTING_DOWN"
+	ErrCodeTimeout              ErrorCode = "TIMEOUT"
+	ErrCodeClientClosed         ErrorCode = "CLIENT_CLOSED"
+	ErrCodeEngineError          ErrorCode = "ENGINE_ERROR"
+	ErrCodeEngineUnavailable    ErrorCode = "ENGINE_UNAVAILABLE"
+	ErrCodeOCRFailed            ErrorCode = "OCR_FAILED"
+	ErrCodeInternal             ErrorCode = "INTERNAL_ERROR"
+)
+
+// apiError 描述一个带错误码和 HTTP 状态码的请求错误
+type apiError struct {
+	Code    ErrorCode
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newAPIError(code ErrorCode, status int, format string, v ...interface{}) *apiError {
+	return &apiError{
+		Code:    code,
+		Status:  status,
+		Message: fmt.Sprintf(format, v...),
+	}
+}
+
+// statusClientClosedRequest 是客户端在响应前断开连接时使用的非标准状态码（同 nginx）
+const statusClientClosedRequest = 499
+
+// contextError 将任务 context 的结束原因转换为 apiError
+func contextError(err error) *apiError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newAPIError(ErrCodeTimeout, http.StatusGatewayTimeout, "请求超过截止时间")
+	}
+	return newAPIError(ErrCodeClientClosed, statusClientClosedRequest, "客户端已取消请求")
+}
+
+// logAttrs 返回错误码、状态码和错误信息的日志字段，可在末尾追加其他字段
+func (e *apiError) logAttrs(extra ...interface{}) []interface{} {
+	return append([]interface{}{"code", e.Code, "status", e.Status, "err", e.Message}, extra...)
+}
+
+// engineUnavailableError 是熔断器打开时返回的错误
+func engineUnavailableError() *apiError {
+	return newAPIError(ErrCodeEngineUnavailable, http.StatusServiceUnavailable, "OCR 引擎暂时不可用，请稍后再试")
+}
+
+// errorResponse 将 apiError 转换为 OCR 响应
+func errorResponse(err *apiError) ocrResponse {
+	return ocrResponse{
+		Code:   err.Code,
+		Error:  err.Message,
+		status: err.Status,
+	}
+}
+
+// writeJSON 以给定状态码输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if resp, ok := v.(ocrResponse); ok && resp.Code != "" {
+		noteErrorCode(w, resp.Code)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError 以 JSON 格式输出错误
+func writeError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.Status, errorResponse(err))
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/suifei/ocr-server/internal/tracing"
+	"github.com/suifei/ocr-server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errURLHostNotAllowed = errors.New("URL 主机不在允许列表中")
+
+// newImageFetchClient 创建用于下载 image_url 的 HTTP 客户端，重定向同样受主机白名单约束
+func (s *Server) newImageFetchClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > s.cfg().URLMaxRedirects {
+				return fmt.Errorf("重定向次数超过限制 %d", s.cfg().URLMaxRedirects)
+			}
+			if err := s.checkImageURL(req.URL); err != nil {
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// checkImageURL 检查 URL 协议和主机是否允许访问
+func (s *Server) checkImageURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("不支持的 URL 协议: %s", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range s.cfg().AllowedURLHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == host {
+			return nil
+		}
+		// "*.example.com" 匹配 example.com 的所有子域名
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return nil
+		}
+	}
+	return errURLHostNotAllowed
+}
+
+// fetchImageURL 下载 image_url 指向的图像，失败时按指数退避重试
+func (s *Server) fetchImageURL(ctx context.Context, rawURL string) ([]byte, *apiError) {
+	if len(s.cfg().AllowedURLHosts) == 0 {
+		return nil, newAPIError(ErrCodeImageURLDisabled, http.StatusForbidden, "服务器未启用 image_url")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "无效的 image_url: %v", err)
+	}
+	if err := s.checkImageURL(u); err != nil {
+		return nil, newAPIError(ErrCodeURLNotAllowed, http.StatusForbidden, "%v", err)
+	}
+
+	// url_fetch_timeout 为 0 时不单独限制下载时间，只受请求自身的截止时间约束
+	reqCtx := ctx
+	timeout := s.cfg().URLFetchTimeout
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var data []byte
+	var apiErr *apiError
+
+	operation := func() error {
+		data, apiErr = s.fetchImageOnce(ctx, u.String())
+		if apiErr == nil {
+			return nil
+		}
+		// 请求自身已超过截止时间或被取消时返回 TIMEOUT/CLIENT_CLOSED，而不是下载失败
+		if err := reqCtx.Err(); err != nil {
+			apiErr = contextError(err)
+			return backoff.Permanent(apiErr)
+		}
+		// 只有网络错误和 5xx 响应值得重试
+		if apiErr.Code != ErrCodeFetchFailed {
+			return backoff.Permanent(apiErr)
+		}
+		utils.LoggerFromContext(ctx).Warning("下载 image_url 失败，准备重试: %v", apiErr)
+		return apiErr
+	}
+
+	backOff := backoff.NewExponentialBackOff()
+	backOff.InitialInterval = 200 * time.Millisecond
+	if timeout > 0 {
+		backOff.MaxElapsedTime = timeout
+	}
+
+	if err := backoff.Retry(operation, backoff.WithContext(backOff, ctx)); err != nil {
+		if err := reqCtx.Err(); err != nil {
+			return nil, contextError(err)
+		}
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return nil, newAPIError(ErrCodeFetchFailed, http.StatusBadGateway, "下载 image_url 失败: %v", err)
+	}
+	return data, nil
+}
+
+func (s *Server) fetchImageOnce(ctx context.Context, rawURL string) (data []byte, apiErr *apiError) {
+	ctx, span := s.tracer.Start(ctx, "GET image_url", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		traceError(span, apiErr)
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "无效的 image_url: %v", err)
+	}
+	span.SetAttributes(attribute.String("server.address", req.URL.Host))
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := s.imageClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errURLHostNotAllowed) {
+			return nil, newAPIError(ErrCodeURLNotAllowed, http.StatusForbidden, "重定向目标主机不在允许列表中")
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, newAPIError(ErrCodeFetchFailed, http.StatusGatewayTimeout, "下载 image_url 超时")
+		}
+		return nil, newAPIError(ErrCodeFetchFailed, http.StatusBadGateway, "下载 image_url 失败: %v", err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode >= 500 {
+		return nil, newAPIError(ErrCodeFetchFailed, http.StatusBadGateway, "image_url 返回状态码 %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(ErrCodeFetchRejected, http.StatusBadGateway, "image_url 返回状态码 %d", resp.StatusCode)
+	}
+
+	limit := s.cfg().MaxImageBytes
+	if limit > 0 && resp.ContentLength > limit {
+		return nil, s.imageBytesTooLarge()
+	}
+
+	reader := io.Reader(resp.Body)
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, newAPIError(ErrCodeFetchFailed, http.StatusBadGateway, "读取 image_url 响应失败: %v", err)
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, s.imageBytesTooLarge()
+	}
+	return data, nil
+}
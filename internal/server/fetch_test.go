@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/config"
+)
+
+func TestFetchImageURLRequestDeadline(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// 响应体迟迟不发送，下载在读取阶段超时
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	s, err := NewServer(config.Config{
+		AllowedURLHosts: []string{"127.0.0.1"},
+		URLFetchTimeout: time.Minute,
+		MaxImageBytes:   1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 请求自身的截止时间先到期时返回 TIMEOUT，而不是 FETCH_FAILED
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, apiErr := s.fetchImageURL(ctx, ts.URL+"/a.png"); apiErr == nil || apiErr.Code != ErrCodeTimeout || apiErr.Status != http.StatusGatewayTimeout {
+		t.Errorf("fetch past the request deadline = %v, want %s", apiErr, ErrCodeTimeout)
+	}
+
+	// url_fetch_timeout 到期时仍是下载失败
+	cfg := *s.cfg()
+	cfg.URLFetchTimeout = 100 * time.Millisecond
+	s.config.Store(&cfg)
+	if _, apiErr := s.fetchImageURL(context.Background(), ts.URL+"/a.png"); apiErr == nil || apiErr.Code != ErrCodeFetchFailed {
+		t.Errorf("fetch past url_fetch_timeout = %v, want %s", apiErr, ErrCodeFetchFailed)
+	}
+}
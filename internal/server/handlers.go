@@ -1,76 +1,313 @@
 package server
 
 import (
-	"encoding/base64"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/suifei/ocr-server/internal/imgproc"
 	"github.com/suifei/ocr-server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ocrRequest struct {
 	ImagePath     string `json:"image_path,omitempty"`
 	Base64Content string `json:"image_base64,omitempty"`
+	ImageURL      string `json:"image_url,omitempty"`
+	Priority      string `json:"priority,omitempty"`
+	// TimeoutMS 是请求的处理截止时间（毫秒），包括排队时间，0 表示不限制
+	TimeoutMS int64 `json:"timeout_ms,omitempty"`
+	// 标注选项：返回绘制了识别框的 PNG 图像
+	Annotate           bool `json:"annotate,omitempty"`
+	AnnotateNumbered   bool `json:"annotate_numbered,omitempty"`
+	AnnotateScoreColor bool `json:"annotate_score_color,omitempty"`
+	// NoCache 为 true 时不读取也不写入结果缓存
+	NoCache bool `json:"no_cache,omitempty"`
+	// Timings 为 true 时在响应中附带各阶段耗时
+	Timings bool `json:"timings,omitempty"`
 }
 
 type ocrResponse struct {
-	Data  interface{} `json:"data,omitempty"`
-	Error string      `json:"error,omitempty"`
+	Data           interface{}   `json:"data,omitempty"`
+	AnnotatedImage string        `json:"annotated_image,omitempty"`
+	Cached         bool          `json:"cached,omitempty"`
+	Timings        *stageTimings `json:"timings,omitempty"`
+	Code           ErrorCode     `json:"code,omitempty"`
+	Error          string        `json:"error,omitempty"`
+	status         int
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	utils.LogInfo("收到获取服务器状态的请求")
+	stats := s.GetStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
 
 func (s *Server) handleOCR(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/stats" {
-		utils.LogInfo("收到获取服务器状态的请求")
-		stats := s.GetStats()
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(stats)
-		return
+	// 请求 ID 出现在响应头和该请求的每一行日志中，便于关联客户端报告和服务器日志
+	requestID := requestIDFor(r)
+	w.Header().Set("X-Request-ID", requestID)
+	reqLog := utils.With("request_id", requestID)
+	span := trace.SpanFromContext(r.Context())
+	if sc := span.SpanContext(); sc.IsValid() {
+		reqLog = reqLog.With("trace_id", sc.TraceID().String())
+		span.SetAttributes(attribute.String("ocr.request_id", requestID))
 	}
 
 	if r.Method != http.MethodPost {
-		utils.LogInfo("收到不支持的请求方法: %s", r.Method)
-		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+		apiErr := newAPIError(ErrCodeMethodNotAllowed, http.StatusMethodNotAllowed, "不支持的请求方法: %s", r.Method)
+		reqLog.Infow("收到不支持的请求方法", apiErr.logAttrs()...)
+		writeError(w, apiErr)
 		return
 	}
 
+	// 先计数再检查排空状态，保证排空开始后计数只减不增
+	atomic.AddInt64(&s.activeRequests, 1)
+	defer atomic.AddInt64(&s.activeRequests, -1)
+
+	if s.draining.Load() {
+		apiErr := newAPIError(ErrCodeShuttingDown, http.StatusServiceUnavailable, "服务器正在关闭，不再接受新请求")
+		reqLog.Infow("服务器正在排空，拒绝新请求", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
+	}
+
+	if s.cfg().MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg().MaxBodyBytes)
+	}
+
 	var req ocrRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.LogInfo("解析 JSON 失败: %v", err)
-		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			apiErr := newAPIError(ErrCodeRequestTooLarge, http.StatusRequestEntityTooLarge, "请求体超过限制 %d 字节", maxBytesErr.Limit)
+			reqLog.Infow("请求体超过限制", apiErr.logAttrs("limit_bytes", maxBytesErr.Limit)...)
+			writeError(w, apiErr)
+			return
+		}
+		apiErr := newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "解析 JSON 失败: %v", err)
+		reqLog.Infow("解析 JSON 失败", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
+	}
+
+	if req.ImagePath == "" && req.Base64Content == "" && req.ImageURL == "" {
+		apiErr := newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "缺少 image_path、image_base64 或 image_url 参数")
+		reqLog.Infow("收到缺少图像数据的请求", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
+	}
+
+	priority, apiErr := s.requestPriority(r, req)
+	if apiErr != nil {
+		reqLog.Infow("无效的优先级", apiErr.logAttrs()...)
+		writeError(w, apiErr)
 		return
 	}
 
-	if req.ImagePath == "" && req.Base64Content == "" {
-		utils.LogInfo("收到缺少图像数据的请求")
-		http.Error(w, "缺少 image_path 或 image_base64 参数", http.StatusBadRequest)
+	if req.TimeoutMS < 0 {
+		writeError(w, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "timeout_ms 不能为负数"))
 		return
 	}
 
-	utils.LogInfo("收到 OCR 请求，正在排队处理")
+	// 客户端断开或超过 timeout_ms 时取消任务
+	ctx := utils.ContextWithLogger(r.Context(), reqLog)
+	if req.TimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMS)*time.Millisecond)
+		defer cancel()
+	}
+
+	loadCtx, loadSpan := s.tracer.Start(ctx, "load_image")
+	imageData, apiErr := s.loadRequestImage(loadCtx, req)
+	traceError(loadSpan, apiErr)
+	loadSpan.SetAttributes(attribute.Int("image.bytes", len(imageData)))
+	loadSpan.End()
+	if apiErr != nil {
+		if apiErr.Code == ErrCodePathNotAllowed || apiErr.Code == ErrCodeImagePathDisabled {
+			reqLog.Warningw("审计：拒绝 image_path 访问", apiErr.logAttrs("client", r.RemoteAddr, "path", req.ImagePath)...)
+		}
+		if apiErr.Code == ErrCodeURLNotAllowed {
+			reqLog.Warningw("审计：拒绝 image_url 访问", apiErr.logAttrs("client", r.RemoteAddr, "url", req.ImageURL)...)
+		}
+		reqLog.Infow("读取图像失败", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
+	}
+	s.metrics.imageSize.Observe(float64(len(imageData)))
+	if apiErr := s.validateImage(imageData); apiErr != nil {
+		reqLog.Infow("图像校验失败", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
+	}
+
+	reqLog.Info("收到 OCR 请求，正在排队处理")
 	task := ocrTask{
+		ID:        requestID,
 		ImagePath: req.ImagePath,
+		ImageData: imageData,
 		Response:  make(chan ocrResponse, 1),
+		Priority:  priority,
+		Ctx:       ctx,
+	}
+	if req.Annotate {
+		task.Annotate = &imgproc.AnnotateOptions{
+			Numbered:     req.AnnotateNumbered,
+			ColorByScore: req.AnnotateScoreColor,
+		}
+	}
+
+	key := s.resultCacheKey(imageData)
+	if s.cache != nil && !req.NoCache {
+		if data, ok := s.lookupResult(key); ok {
+			reqLog.Info("命中结果缓存")
+			span.SetAttributes(attribute.Bool("ocr.cached", true))
+			writeJSON(w, http.StatusOK, s.respondCached(task, data))
+			return
+		}
+		task.CacheKey = key
 	}
 
-	if req.Base64Content != "" {
-		imageData, err := base64.StdEncoding.DecodeString(req.Base64Content)
+	// 相同图像和选项的请求正在识别时等待其结果，而不是再占用一个处理器。
+	// no_cache 要求重新识别，既不复用也不共享其他请求的识别结果
+	var call *flightCall
+	if !req.NoCache {
+		leader, shared, err := s.awaitFlight(ctx, key)
 		if err != nil {
-			utils.LogInfo("无效的 base64 图像数据: %v", err)
-			http.Error(w, "无效的 base64 图像数据", http.StatusBadRequest)
+			apiErr := contextError(err)
+			reqLog.Infow("等待相同请求的结果时取消", apiErr.logAttrs()...)
+			writeError(w, apiErr)
 			return
 		}
-		task.ImageData = imageData
+		if leader == nil {
+			span.SetAttributes(attribute.Bool("ocr.deduplicated", true))
+			if shared.err != nil {
+				reqLog.Infow("复用相同请求的识别错误", shared.err.logAttrs()...)
+				traceError(span, shared.err)
+				writeJSON(w, shared.err.Status, s.respondSharedError(shared.err))
+				return
+			}
+			reqLog.Info("复用相同请求的识别结果")
+			writeJSON(w, http.StatusOK, s.respondShared(task, shared.data))
+			return
+		}
+		call = leader
+		defer s.flights.finish(key, call)
+	}
+
+	// 熔断器打开时不再排队等待必然失败的引擎
+	if remaining, open := s.breaker.RetryAfter(); open {
+		apiErr := engineUnavailableError()
+		reqLog.Infow("熔断器已打开，拒绝任务", apiErr.logAttrs("retry_after_ms", millis(remaining))...)
+		s.rejectBusy(w, apiErr, remaining)
+		return
+	}
+
+	wait := s.estimateQueueWait()
+	if apiErr := s.checkDeadlineAdmission(task, wait); apiErr != nil {
+		reqLog.Infow("任务无法在截止时间内完成，拒绝", apiErr.logAttrs("wait_ms", millis(wait))...)
+		s.rejectBusy(w, apiErr, wait)
+		return
+	}
+
+	task.EnqueuedAt = time.Now()
+	_, enqueueSpan := s.tracer.Start(ctx, "enqueue", trace.WithAttributes(
+		attribute.String("ocr.priority", priority.String()),
+		attribute.Int("queue.length", s.queue.Len()),
+	))
+	err := s.queue.Push(ctx, task, s.cfg().EnqueueWait)
+	recordError(enqueueSpan, err)
+	enqueueSpan.End()
+	if err != nil {
+		if errors.Is(err, errQueueFull) {
+			apiErr := newAPIError(ErrCodeServerBusy, http.StatusTooManyRequests, "服务器繁忙，请稍后再试")
+			reqLog.Infow("任务队列已满，拒绝请求", apiErr.logAttrs("priority", priority.String())...)
+			s.rejectBusy(w, apiErr, s.estimateQueueWait())
+			return
+		}
+		apiErr := contextError(err)
+		reqLog.Infow("任务入队前已取消", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+		return
 	}
 
+	reqLog.Infow("任务已进入队列", "priority", priority.String())
 	select {
-	case s.taskQueue <- task:
-		utils.LogInfo("任务队列处理器已启动")
-		response := <-task.Response
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-	case <-time.After(10 * time.Second):
-		utils.LogInfo("任务队列已满，请求超时")
-		http.Error(w, "服务器繁忙，请稍后再试", http.StatusServiceUnavailable)
+	case response := <-task.Response:
+		status := response.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if call != nil {
+			call.settle(response)
+		}
+		setServerTiming(w, response.Timings)
+		if !req.Timings {
+			response.Timings = nil
+		}
+		writeJSON(w, status, response)
+	case <-ctx.Done():
+		// 响应通道有缓冲，工作协程之后仍可写入而不会阻塞
+		apiErr := contextError(ctx.Err())
+		reqLog.Infow("等待结果时取消", apiErr.logAttrs()...)
+		writeError(w, apiErr)
+	}
+}
+
+// requestPriority 确定请求的优先级：配置了优先级的 API Key 优先，其次是请求中的 priority 字段
+func (s *Server) requestPriority(r *http.Request, req ocrRequest) (taskPriority, *apiError) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		for _, kp := range s.cfg().APIKeyPriorities {
+			if kp.Key == key {
+				priority, _ := parsePriority(kp.Priority)
+				return priority, nil
+			}
+		}
+	}
+
+	priority, ok := parsePriority(req.Priority)
+	if !ok {
+		return priorityNormal, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "无效的 priority: %s，可选值为 high、normal、bulk", req.Priority)
+	}
+	return priority, nil
+}
+
+// requestIDFor 返回请求 ID：沿用调用方在 X-Request-ID 中传入的合法 ID，否则生成新的 ID
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+// validRequestID 只接受长度不超过 128 的字母、数字和 ._- 组成的 ID，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成用于日志关联的随机请求 ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
 	}
+	return hex.EncodeToString(b)
 }
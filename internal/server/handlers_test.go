@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/imgproc"
+)
+
+func TestNoCacheSkipsInFlightRequests(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	probe, err := imgproc.ProbeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟一个相同图像的识别正在进行
+	key := s.resultCacheKey(probe)
+	call, leader := s.flights.join(key)
+	if !leader {
+		t.Fatal("flight already in progress")
+	}
+	defer s.flights.finish(key, call)
+
+	// no_cache 请求不等待进行中的识别，而是自己重新识别
+	body := `{"image_base64":"` + base64.StdEncoding.EncodeToString(probe) + `","no_cache":true}`
+	done := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(ts.URL+"/ocr", "application/json", strings.NewReader(body))
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	select {
+	case status := <-done:
+		if status != http.StatusOK {
+			t.Errorf("no_cache request = %d, want 200", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no_cache request waited for the in-flight request")
+	}
+}
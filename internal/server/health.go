@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// routes 注册服务器的 HTTP 路由，其余路径均由 handleOCR 处理
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.registerAdminRoutes(mux)
+	mux.HandleFunc("/", s.instrumentOCR(s.handleOCR))
+	return mux
+}
+
+// handleHealthz 是存活探针：只要进程能够响应 HTTP 请求即返回 200
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"uptime": time.Since(s.startedAt).Seconds(),
+	})
+}
+
+// handleReadyz 是就绪探针：处理器已初始化、熔断器关闭且未处于排空状态时返回 200，否则返回 503
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	snap := s.pool.Snapshot()
+	breakerState := s.breaker.State()
+
+	checks := map[string]interface{}{
+		"initialized":     s.ready.Load(),
+		"draining":        s.draining.Load(),
+		"circuit_breaker": breakerState.String(),
+		"processors":      snap.Total,
+		"idle_processors": snap.Idle,
+	}
+
+	var reasons []string
+	if !s.ready.Load() {
+		reasons = append(reasons, "处理器尚未初始化")
+	}
+	if s.draining.Load() {
+		reasons = append(reasons, "服务器正在排空")
+	}
+	if breakerState != breakerClosed {
+		reasons = append(reasons, "熔断器未关闭")
+	}
+	if snap.Total == 0 {
+		reasons = append(reasons, "没有可用的处理器")
+	}
+
+	status := http.StatusOK
+	body := map[string]interface{}{
+		"status": "ready",
+		"checks": checks,
+	}
+	if len(reasons) > 0 {
+		status = http.StatusServiceUnavailable
+		body["status"] = "not_ready"
+		body["reasons"] = reasons
+	}
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+// readImageFile 在沙箱限制下读取 image_path 指向的文件
+func (s *Server) readImageFile(path string) ([]byte, *apiError) {
+	resolved, apiErr := s.resolveImagePath(path)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, newAPIError(ErrCodeFileNotFound, http.StatusNotFound, "图像文件不存在: %s", path)
+		}
+		return nil, newAPIError(ErrCodeFileNotFound, http.StatusBadRequest, "无法访问图像文件: %v", err)
+	}
+	if info.IsDir() {
+		return nil, newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "image_path 指向的是目录: %s", path)
+	}
+
+	if s.cfg().MaxImageBytes > 0 && info.Size() > s.cfg().MaxImageBytes {
+		return nil, s.imageBytesTooLarge()
+	}
+
+	data, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, newAPIError(ErrCodeFileNotFound, http.StatusBadRequest, "读取图像文件失败: %v", err)
+	}
+	return data, nil
+}
+
+// imageRoots 是配置加载时解析好的 allowed_image_dirs
+type imageRoots struct {
+	abs      []string // 配置的目录转为绝对路径，用于访问文件系统前的词法检查
+	resolved []string // 解析符号链接后的真实路径，用于检查解析后的 image_path
+}
+
+// newImageRoots 解析允许目录的绝对路径和符号链接，无法解析的目录会被忽略
+func newImageRoots(dirs []string) *imageRoots {
+	roots := &imageRoots{}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			utils.LogWarning("忽略无效的 allowed_image_dirs 目录 %s: %v", dir, err)
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			utils.LogWarning("忽略无法访问的 allowed_image_dirs 目录 %s: %v", dir, err)
+			continue
+		}
+		roots.abs = append(roots.abs, abs)
+		roots.resolved = append(roots.resolved, resolved)
+	}
+	return roots
+}
+
+// resolveImagePath 规范化路径并解析符号链接，确保最终路径位于允许的目录中。
+// 未配置 allowed_image_dirs 时不做目录限制。
+func (s *Server) resolveImagePath(path string) (string, *apiError) {
+	if s.cfg().DisableImagePath {
+		return "", newAPIError(ErrCodeImagePathDisabled, http.StatusForbidden, "服务器已禁用 image_path，请使用 image_base64")
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", newAPIError(ErrCodeInvalidRequest, http.StatusBadRequest, "无效的 image_path: %v", err)
+	}
+
+	if len(s.cfg().AllowedImageDirs) == 0 {
+		return absPath, nil
+	}
+	roots := s.imageRoots.Load()
+
+	// 先做词法检查，避免为允许目录之外的路径访问文件系统。
+	// 允许目录本身可能是符号链接，因此配置的路径和解析后的路径都可以作为前缀
+	if !underAnyRoot(roots.abs, absPath) && !underAnyRoot(roots.resolved, absPath) {
+		return "", newAPIError(ErrCodePathNotAllowed, http.StatusForbidden, "image_path 不在允许的目录中")
+	}
+
+	resolved, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", newAPIError(ErrCodeFileNotFound, http.StatusNotFound, "图像文件不存在: %s", path)
+		}
+		return "", newAPIError(ErrCodeFileNotFound, http.StatusBadRequest, "无法访问图像文件: %v", err)
+	}
+
+	// 符号链接可能指向允许目录之外，解析后的路径只与解析后的允许目录比较
+	if !underAnyRoot(roots.resolved, resolved) {
+		return "", newAPIError(ErrCodePathNotAllowed, http.StatusForbidden, "image_path 不在允许的目录中")
+	}
+
+	return resolved, nil
+}
+
+// underAnyRoot 判断 path 是否位于 roots 中的某个目录之下
+func underAnyRoot(roots []string, path string) bool {
+	for _, root := range roots {
+		if isSubPath(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubPath 判断 path 是否等于 root 或位于 root 之下
+func isSubPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/suifei/ocr-server/internal/config"
+)
+
+func TestResolveImagePathWithSymlinkedRoot(t *testing.T) {
+	base := t.TempDir()
+	volume := filepath.Join(base, "mnt", "vol")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{volume, outside} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 允许目录本身是指向真实存储的符号链接：/data -> /mnt/vol
+	data := filepath.Join(base, "data")
+	if err := os.Symlink(volume, data); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	for _, name := range []string{filepath.Join(volume, "a.png"), filepath.Join(outside, "secret.png")} {
+		if err := os.WriteFile(name, []byte("png"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.png"), filepath.Join(volume, "escape.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	cfg := config.Config{AllowedImageDirs: []string{data}}
+	s.config.Store(&cfg)
+	s.imageRoots.Store(newImageRoots(cfg.AllowedImageDirs))
+
+	tests := []struct {
+		path string
+		want string    // 解析后的路径，为空时应返回错误
+		code ErrorCode // 期望的错误码
+	}{
+		{path: filepath.Join(data, "a.png"), want: filepath.Join(volume, "a.png")},
+		{path: filepath.Join(volume, "a.png"), want: filepath.Join(volume, "a.png")},
+		{path: filepath.Join(data, "sub", "..", "a.png"), want: filepath.Join(volume, "a.png")},
+		{path: filepath.Join(data, "missing.png"), code: ErrCodeFileNotFound},
+		{path: filepath.Join(data, "escape.png"), code: ErrCodePathNotAllowed},
+		{path: filepath.Join(data, "..", "outside", "secret.png"), code: ErrCodePathNotAllowed},
+		{path: filepath.Join(outside, "secret.png"), code: ErrCodePathNotAllowed},
+		// 允许目录之外的路径不访问文件系统，因此不会暴露文件是否存在
+		{path: filepath.Join(outside, "missing.png"), code: ErrCodePathNotAllowed},
+	}
+	for _, tt := range tests {
+		got, apiErr := s.resolveImagePath(tt.path)
+		if tt.want != "" {
+			if apiErr != nil {
+				t.Errorf("resolveImagePath(%s) = %v, want %s", tt.path, apiErr, tt.want)
+			} else if got != tt.want {
+				t.Errorf("resolveImagePath(%s) = %s, want %s", tt.path, got, tt.want)
+			}
+			continue
+		}
+		if apiErr == nil || apiErr.Code != tt.code {
+			t.Errorf("resolveImagePath(%s) = %q, %v, want %s", tt.path, got, apiErr, tt.code)
+		}
+	}
+}
+
+func TestNewImageRootsSkipsMissingDirs(t *testing.T) {
+	dir := t.TempDir()
+	roots := newImageRoots([]string{filepath.Join(dir, "missing"), dir})
+	if len(roots.resolved) != 1 || len(roots.abs) != 1 {
+		t.Fatalf("roots = %+v, want only the existing directory", roots)
+	}
+
+	// 配置了允许目录但都无法访问时拒绝所有路径，而不是放开限制
+	s := &Server{}
+	cfg := config.Config{AllowedImageDirs: []string{filepath.Join(dir, "missing")}}
+	s.config.Store(&cfg)
+	s.imageRoots.Store(newImageRoots(cfg.AllowedImageDirs))
+	if _, apiErr := s.resolveImagePath(filepath.Join(dir, "missing", "a.png")); apiErr == nil || apiErr.Code != ErrCodePathNotAllowed {
+		t.Errorf("resolveImagePath with no usable roots = %v, want %s", apiErr, ErrCodePathNotAllowed)
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// 滚动窗口的分桶粒度和覆盖范围
+	latencySlot      = 10 * time.Second
+	latencySlots     = 90 // 15 分钟
+	latencyHistoryN  = 60 // 每分钟历史保留 60 条
+	latencyBuckets   = 64
+	latencyMinBound  = time.Millisecond
+	latencyBoundStep = 1.2 // 相邻延迟分桶上界的比例，最大约 97 秒
+)
+
+// latencyWindows 是 /stats 中报告的统计窗口
+var latencyWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+// latencyBounds 是延迟直方图各分桶的上界，最后一个分桶收纳所有更大的值
+var latencyBounds = func() [latencyBuckets]time.Duration {
+	var bounds [latencyBuckets]time.Duration
+	bound := float64(latencyMinBound)
+	for i := range bounds {
+		bounds[i] = time.Duration(bound)
+		bound *= latencyBoundStep
+	}
+	bounds[latencyBuckets-1] = time.Duration(math.MaxInt64)
+	return bounds
+}()
+
+// latencyBucket 汇总一段时间内的任务数、失败数和延迟分布
+type latencyBucket struct {
+	start  time.Time // 所属时间段的起点，用于判断分桶是否过期
+	count  int64
+	errors int64
+	sum    time.Duration
+	hist   [latencyBuckets]int64
+}
+
+func (b *latencyBucket) reset(start time.Time) {
+	*b = latencyBucket{start: start}
+}
+
+func (b *latencyBucket) add(d time.Duration, success bool) {
+	b.count++
+	if !success {
+		b.errors++
+	}
+	b.sum += d
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			b.hist[i]++
+			return
+		}
+	}
+}
+
+func (b *latencyBucket) merge(o *latencyBucket) {
+	b.count += o.count
+	b.errors += o.errors
+	b.sum += o.sum
+	for i := range b.hist {
+		b.hist[i] += o.hist[i]
+	}
+}
+
+// quantile 根据直方图估算分位数，在分桶内做线性插值
+func (b *latencyBucket) quantile(q float64) time.Duration {
+	if b.count == 0 {
+		return 0
+	}
+	rank := q * float64(b.count)
+	var cumulative int64
+	for i, n := range b.hist {
+		if n == 0 {
+			continue
+		}
+		if float64(cumulative+n) >= rank {
+			lower := time.Duration(0)
+			if i > 0 {
+				lower = latencyBounds[i-1]
+			}
+			upper := latencyBounds[i]
+			if i == latencyBuckets-1 {
+				// 溢出分桶没有上界，只能报告下界
+				return lower
+			}
+			frac := (rank - float64(cumulative)) / float64(n)
+			return lower + time.Duration(frac*float64(upper-lower))
+		}
+		cumulative += n
+	}
+	return latencyBounds[latencyBuckets-2]
+}
+
+func (b *latencyBucket) mean() time.Duration {
+	if b.count == 0 {
+		return 0
+	}
+	return b.sum / time.Duration(b.count)
+}
+
+// latencyRecorder 以 10 秒为粒度记录最近 15 分钟的任务延迟，并按分钟保留历史，
+// 可并发调用
+type latencyRecorder struct {
+	mu      sync.Mutex
+	started time.Time
+	slots   [latencySlots]latencyBucket
+	history [latencyHistoryN]latencyBucket
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{started: time.Now()}
+}
+
+// Record 记录一个任务的处理时间和结果
+func (r *latencyRecorder) Record(d time.Duration, success bool) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slotStart := now.Truncate(latencySlot)
+	slot := &r.slots[slotStart.Unix()/int64(latencySlot/time.Second)%latencySlots]
+	if !slot.start.Equal(slotStart) {
+		slot.reset(slotStart)
+	}
+	slot.add(d, success)
+
+	minute := now.Truncate(time.Minute)
+	entry := &r.history[minute.Unix()/60%latencyHistoryN]
+	if !entry.start.Equal(minute) {
+		entry.reset(minute)
+	}
+	entry.add(d, success)
+}
+
+// window 合并最近 d 时间内的分桶
+func (r *latencyRecorder) window(now time.Time, d time.Duration) latencyBucket {
+	var total latencyBucket
+	oldest := now.Truncate(latencySlot).Add(-d + latencySlot)
+	for i := range r.slots {
+		slot := &r.slots[i]
+		if slot.count > 0 && !slot.start.Before(oldest) && !slot.start.After(now) {
+			total.merge(slot)
+		}
+	}
+	return total
+}
+
+// Mean 返回最近 d 时间内的平均处理时间，没有样本时返回 0
+func (r *latencyRecorder) Mean(d time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.window(time.Now(), d)
+	return w.mean()
+}
+
+// RecentMean 返回最近 5 分钟的平均处理时间，没有样本时退回到 15 分钟
+func (r *latencyRecorder) RecentMean() time.Duration {
+	if mean := r.Mean(5 * time.Minute); mean > 0 {
+		return mean
+	}
+	return r.Mean(15 * time.Minute)
+}
+
+// windowStats 是一个统计窗口的汇总
+type windowStats struct {
+	Count      int64   `json:"count"`
+	Throughput float64 `json:"throughput"` // 每秒任务数
+	ErrorRate  float64 `json:"error_rate"` // 百分比
+	Mean       float64 `json:"mean"`       // 秒
+	P50        float64 `json:"p50"`
+	P90        float64 `json:"p90"`
+	P99        float64 `json:"p99"`
+}
+
+// historyEntry 是一分钟的汇总，用于绘制趋势图
+type historyEntry struct {
+	Minute time.Time `json:"minute"`
+	windowStats
+}
+
+func summarize(b *latencyBucket, span time.Duration) windowStats {
+	ws := windowStats{
+		Count: b.count,
+		Mean:  b.mean().Seconds(),
+		P50:   b.quantile(0.50).Seconds(),
+		P90:   b.quantile(0.90).Seconds(),
+		P99:   b.quantile(0.99).Seconds(),
+	}
+	if span > 0 {
+		ws.Throughput = float64(b.count) / span.Seconds()
+	}
+	if b.count > 0 {
+		ws.ErrorRate = float64(b.errors) / float64(b.count) * 100
+	}
+	return ws
+}
+
+// Windows 返回 1、5、15 分钟窗口的统计
+func (r *latencyRecorder) Windows() map[string]windowStats {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make(map[string]windowStats, len(latencyWindows))
+	for _, w := range latencyWindows {
+		b := r.window(now, w.d)
+		// 启动不足一个窗口时按实际运行时间计算吞吐量
+		span := w.d
+		if uptime := now.Sub(r.started); uptime < span {
+			span = uptime
+		}
+		stats[w.name] = summarize(&b, span)
+	}
+	return stats
+}
+
+// History 按时间顺序返回最近一小时内每分钟的统计，当前分钟尚未结束
+func (r *latencyRecorder) History() []historyEntry {
+	now := time.Now()
+	oldest := now.Truncate(time.Minute).Add(-(latencyHistoryN - 1) * time.Minute)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := make([]historyEntry, 0, latencyHistoryN)
+	for i := 0; i < latencyHistoryN; i++ {
+		minute := oldest.Add(time.Duration(i) * time.Minute)
+		entry := &r.history[minute.Unix()/60%latencyHistoryN]
+		if entry.count == 0 || !entry.start.Equal(minute) {
+			continue
+		}
+		history = append(history, historyEntry{Minute: minute, windowStats: summarize(entry, time.Minute)})
+	}
+	return history
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/metrics"
+	"github.com/suifei/ocr-server/internal/tracing"
+	"github.com/suifei/ocr-server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serverMetrics 是 /metrics 暴露的 Prometheus 指标
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	requests   *metrics.CounterVec
+	queueWait  *metrics.Histogram
+	preprocess *metrics.Histogram
+	engine     *metrics.Histogram
+	latency    *metrics.Histogram
+	imageSize  *metrics.Histogram
+}
+
+func (s *Server) newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	durations := metrics.ExponentialBuckets(0.005, 2, 14) // 5ms ~ 41s
+
+	m := &serverMetrics{
+		registry: r,
+		requests: r.NewCounterVec("ocr_requests_total",
+			"OCR requests by outcome and error code.", "outcome", "code"),
+		queueWait: r.NewHistogram("ocr_queue_wait_seconds",
+			"Time tasks spend in the queue before getting a processor.", durations),
+		preprocess: r.NewHistogram("ocr_preprocess_seconds",
+			"Time spent decoding and preprocessing images.", durations),
+		engine: r.NewHistogram("ocr_engine_seconds",
+			"Time spent in the OCR engine, including retries.", durations),
+		latency: r.NewHistogram("ocr_request_duration_seconds",
+			"Total latency of OCR requests.", durations),
+		imageSize: r.NewHistogram("ocr_image_size_bytes",
+			"Size of submitted images.", metrics.ExponentialBuckets(16<<10, 4, 8)), // 16KB ~ 256MB
+	}
+
+	r.NewGaugeVecFunc("ocr_processors", "Processors by state.", "state", func() map[string]float64 {
+		snap := s.pool.Snapshot()
+		return map[string]float64{
+			"idle":     float64(snap.Idle),
+			"in_use":   float64(snap.InUse),
+			"creating": float64(snap.Creating),
+		}
+	})
+	r.NewGaugeFunc("ocr_processors_min", "Configured minimum number of processors.", func() float64 {
+		return float64(s.pool.Snapshot().Min)
+	})
+	r.NewGaugeFunc("ocr_processors_max", "Configured maximum number of processors.", func() float64 {
+		return float64(s.pool.Snapshot().Max)
+	})
+	r.NewGaugeFunc("ocr_waiting_tasks", "Tasks waiting for a processor.", func() float64 {
+		return float64(s.pool.Snapshot().Waiting)
+	})
+	r.NewGaugeVecFunc("ocr_queue_length", "Queued tasks by priority.", "priority", func() map[string]float64 {
+		depths := make(map[string]float64)
+		for name, n := range s.queue.Depths() {
+			depths[name] = float64(n)
+		}
+		return depths
+	})
+	r.NewGaugeVecFunc("ocr_circuit_breaker_state", "1 for the current circuit breaker state.", "state", func() map[string]float64 {
+		current := s.breaker.State()
+		states := make(map[string]float64, len(breakerStateNames))
+		for i, name := range breakerStateNames {
+			states[name] = 0
+			if breakerState(i) == current {
+				states[name] = 1
+			}
+		}
+		return states
+	})
+	r.NewCounterFunc("ocr_panics_total", "Panics recovered while processing tasks.", func() float64 {
+		return float64(atomic.LoadInt64(&s.stats.PanicCount))
+	})
+	r.NewCounterFunc("ocr_recycled_processors_total", "Processors recycled after reaching a limit.", func() float64 {
+		return float64(atomic.LoadInt64(&s.stats.RecycledProcessors))
+	})
+	r.NewCounterFunc("ocr_deduplicated_requests_total", "Requests served by an identical in-flight request.", func() float64 {
+		return float64(atomic.LoadInt64(&s.stats.DeduplicatedRequests))
+	})
+	r.NewCounterFunc("ocr_cache_hits_total", "Result cache hits.", func() float64 {
+		if s.cache == nil {
+			return 0
+		}
+		return float64(s.cache.Stats().Hits)
+	})
+	r.NewCounterFunc("ocr_cache_misses_total", "Result cache misses.", func() float64 {
+		if s.cache == nil {
+			return 0
+		}
+		return float64(s.cache.Stats().Misses)
+	})
+
+	return m
+}
+
+// handleMetrics 以 Prometheus 文本格式输出指标
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := s.metrics.registry.WriteText(w); err != nil {
+		utils.LogWarning("输出指标失败: %v", err)
+	}
+}
+
+// metricsRecorder 记录 OCR 请求的状态码和错误码
+type metricsRecorder struct {
+	http.ResponseWriter
+	status int
+	code   ErrorCode
+}
+
+func (rec *metricsRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// noteErrorCode 在响应经过 metricsRecorder 时记录错误码
+func noteErrorCode(w http.ResponseWriter, code ErrorCode) {
+	if rec, ok := w.(*metricsRecorder); ok {
+		rec.code = code
+	}
+}
+
+// instrumentOCR 统计 OCR 请求的结果和总延迟，并为请求创建链路追踪的根 span，
+// 调用方通过 traceparent 请求头传入的追踪上下文作为其父 span
+func (s *Server) instrumentOCR(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx, span := s.tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			))
+		defer span.End()
+
+		rec := &metricsRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(ctx))
+
+		outcome := "success"
+		if rec.status >= 400 {
+			outcome = "error"
+		}
+		code := string(rec.code)
+		if code == "" {
+			code = "none"
+		}
+		s.metrics.requests.Inc(outcome, code)
+		s.metrics.latency.Observe(time.Since(start).Seconds())
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.code != "" {
+			span.SetAttributes(attribute.String("ocr.error_code", code))
+		}
+		// 与 OpenTelemetry 的约定一致，只有 5xx 才将服务端 span 标记为失败
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("%d %s", rec.status, code))
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/suifei/ocr-server/internal/config"
+	"github.com/suifei/ocr-server/internal/imgproc"
+	"github.com/suifei/ocr-server/internal/metrics"
+)
+
+// newTestServer 创建使用 fakeEngine 的服务器并启动任务调度，测试结束时停止
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	cfg := config.Config{
+		MinProcessors:        1,
+		MaxProcessors:        2,
+		QueueSize:            10,
+		PriorityWeightHigh:   1,
+		PriorityWeightNormal: 1,
+		PriorityWeightBulk:   1,
+		MaxImageWidth:        4096,
+		MaxImageHeight:       4096,
+		MaxImagePixels:       1 << 24,
+		MaxBodyBytes:         1 << 20,
+		MaxImageBytes:        1 << 20,
+		DisableImagePath:     true,
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFactory{}
+	s.pool = newProcessorPool(f.newEngine, cfg.MinProcessors, cfg.MaxProcessors, true)
+	s.ready.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.processQueue(ctx)
+	t.Cleanup(func() {
+		cancel()
+		s.wg.Wait()
+		s.pool.Close()
+	})
+	return s
+}
+
+// scrapeMetrics 抓取 /metrics 并解析为 "名称{标签}" 到值的映射，标签按输出顺序保留
+func scrapeMetrics(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, metrics.ContentType)
+	}
+
+	samples := make(map[string]float64)
+	typed := make(map[string]bool)
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, _, _ := strings.Cut(rest, " ")
+			typed[name] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("malformed value in %q: %v", line, err)
+		}
+		series := line[:i]
+		name, _, _ := strings.Cut(series, "{")
+		base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if !typed[name] && !typed[base] {
+			t.Fatalf("sample %q has no TYPE line", line)
+		}
+		if _, dup := samples[series]; dup {
+			t.Fatalf("duplicate series %q", series)
+		}
+		samples[series] = v
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+func postOCR(t *testing.T, url, body string) (int, ocrResponse) {
+	t.Helper()
+	resp, err := http.Post(url+"/ocr", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var out ocrResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("decode response %q: %v", data, err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestMetricsScrape(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	const (
+		success      = `ocr_requests_total{outcome="success",code="none"}`
+		invalid      = `ocr_requests_total{outcome="error",code="INVALID_REQUEST"}`
+		badMethod    = `ocr_requests_total{outcome="error",code="METHOD_NOT_ALLOWED"}`
+		latencyCount = "ocr_request_duration_seconds_count"
+		latencyInf   = `ocr_request_duration_seconds_bucket{le="+Inf"}`
+	)
+
+	before := scrapeMetrics(t, ts.URL)
+	for _, series := range []string{success, invalid, badMethod} {
+		if _, ok := before[series]; ok {
+			t.Errorf("%s present before any request", series)
+		}
+	}
+	if before[latencyCount] != 0 || before[latencyInf] != 0 {
+		t.Errorf("latency histogram not empty before any request")
+	}
+	if before[`ocr_processors{state="idle"}`] != 0 || before["ocr_processors_max"] != 2 {
+		t.Errorf("processor gauges = idle %v, max %v", before[`ocr_processors{state="idle"}`], before["ocr_processors_max"])
+	}
+
+	probe, err := imgproc.ProbeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := `{"image_base64":"` + base64.StdEncoding.EncodeToString(probe) + `","no_cache":true}`
+	for i := 0; i < 2; i++ {
+		if status, resp := postOCR(t, ts.URL, image); status != http.StatusOK {
+			t.Fatalf("OCR request = %d %s: %s", status, resp.Code, resp.Error)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if status, _ := postOCR(t, ts.URL, `{}`); status != http.StatusBadRequest {
+			t.Fatalf("empty request = %d, want 400", status)
+		}
+	}
+	resp, err := http.Get(ts.URL + "/ocr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	after := scrapeMetrics(t, ts.URL)
+	for series, want := range map[string]float64{success: 2, invalid: 3, badMethod: 1} {
+		if got := after[series]; got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+
+	// 每个请求都计入总延迟直方图，且各桶累加不减，+Inf 桶等于总数
+	if after[latencyCount] != 6 || after[latencyInf] != after[latencyCount] {
+		t.Errorf("latency count = %v, +Inf bucket = %v, want both 6", after[latencyCount], after[latencyInf])
+	}
+	checkCumulative(t, after, "ocr_request_duration_seconds", metrics.ExponentialBuckets(0.005, 2, 14))
+	// 只有成功进入处理的请求才记录排队、预处理和引擎耗时
+	for _, name := range []string{"ocr_queue_wait_seconds", "ocr_preprocess_seconds", "ocr_engine_seconds"} {
+		if got := after[name+"_count"]; got != 2 {
+			t.Errorf("%s_count = %v, want 2", name, got)
+		}
+		if got := after[name+`_bucket{le="+Inf"}`]; got != 2 {
+			t.Errorf("%s +Inf bucket = %v, want 2", name, got)
+		}
+		checkCumulative(t, after, name, metrics.ExponentialBuckets(0.005, 2, 14))
+	}
+	if got := after["ocr_image_size_bytes_count"]; got != 2 {
+		t.Errorf("ocr_image_size_bytes_count = %v, want 2", got)
+	}
+
+	waitFor(t, func() bool { return s.pool.Snapshot().InUse == 0 })
+	final := scrapeMetrics(t, ts.URL)
+	if final[`ocr_processors{state="idle"}`] < 1 || final[`ocr_processors{state="in_use"}`] != 0 {
+		t.Errorf("processor gauges after requests: idle %v, in_use %v",
+			final[`ocr_processors{state="idle"}`], final[`ocr_processors{state="in_use"}`])
+	}
+}
+
+// checkCumulative 检查直方图的每个桶都存在且计数不小于前一个桶
+func checkCumulative(t *testing.T, samples map[string]float64, name string, buckets []float64) {
+	t.Helper()
+	var prev float64
+	for _, upper := range buckets {
+		series := name + `_bucket{le="` + strconv.FormatFloat(upper, 'g', -1, 64) + `"}`
+		got, ok := samples[series]
+		if !ok {
+			t.Errorf("missing %s", series)
+			continue
+		}
+		if got < prev {
+			t.Errorf("%s = %v is below the previous bucket %v", series, got, prev)
+		}
+		prev = got
+	}
+	if inf := samples[name+`_bucket{le="+Inf"}`]; inf < prev {
+		t.Errorf("%s +Inf bucket = %v is below the last bucket %v", name, inf, prev)
+	}
+}
+
+func TestMetricsScrapeDuringShutdown(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	s.draining.Store(true)
+	status, resp := postOCR(t, ts.URL, `{"image_base64":"aGVsbG8="}`)
+	if status != http.StatusServiceUnavailable || resp.Code != ErrCodeShuttingDown {
+		t.Fatalf("request while draining = %d %s", status, resp.Code)
+	}
+
+	// 排空期间仍可抓取指标
+	samples := scrapeMetrics(t, ts.URL)
+	if got := samples[`ocr_requests_total{outcome="error",code="SHUTTING_DOWN"}`]; got != 1 {
+		t.Errorf("SHUTTING_DOWN requests = %v, want 1", got)
+	}
+}
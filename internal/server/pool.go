@@ -0,0 +1,487 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// ocrEngine 是处理器所使用的 OCR 引擎接口，*ocrengine.Process 实现了该接口
+type ocrEngine interface {
+	OcrAndParse(image []byte) (paddleocr.Result, error)
+	Close() error
+}
+
+// engineFactory 创建一个新的 OCR 引擎进程
+type engineFactory func() (ocrEngine, error)
+
+var errPoolClosed = errors.New("处理器池已关闭")
+
+// processorPool 管理 OCR 处理器的获取与归还。
+// 所有状态由 mu 保护，引擎的创建和关闭都在锁外进行；
+// 等待者通过 changed 通道接收状态变化通知，因此可以同时响应 ctx 取消。
+type processorPool struct {
+	newEngine engineFactory
+
+	mu         sync.Mutex
+	minSize    int
+	maxSize    int
+	processors []*OCRProcessor // 所有存活的处理器
+	idle       []*OCRProcessor // 空闲可用的处理器，是 processors 的子集
+	creating   int             // 正在启动中的处理器数量
+	waiting    int             // 正在等待处理器的请求数量
+	changed    chan struct{}   // 状态变化时关闭并替换
+	closed     bool
+	// growOnDemand 为 true 时 Acquire 可按需扩容到 maxSize，
+	// 否则只补足到 minSize，其余扩容交给自动伸缩器
+	growOnDemand bool
+	nextID       int64
+}
+
+// poolSnapshot 是处理器池某一时刻的计数
+type poolSnapshot struct {
+	Total    int
+	Idle     int
+	InUse    int
+	Creating int
+	Waiting  int
+	Min      int
+	Max      int
+}
+
+// processorInfo 描述单个处理器的状态，供管理接口使用
+type processorInfo struct {
+	ID             int64     `json:"id"`
+	State          string    `json:"state"`
+	Jobs           int64     `json:"jobs"`
+	ActiveCalls    int64     `json:"active_calls"`
+	Age            float64   `json:"age"`
+	LastUsed       time.Time `json:"last_used"`
+	LastError      string    `json:"last_error,omitempty"`
+	RestartPending bool      `json:"restart_pending,omitempty"`
+}
+
+func newProcessorPool(factory engineFactory, minSize, maxSize int, growOnDemand bool) *processorPool {
+	return &processorPool{
+		newEngine:    factory,
+		minSize:      minSize,
+		maxSize:      maxSize,
+		changed:      make(chan struct{}),
+		growOnDemand: growOnDemand,
+	}
+}
+
+// notifyLocked 唤醒所有等待者，调用时必须持有 mu
+func (p *processorPool) notifyLocked() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Acquire 获取一个空闲处理器；没有空闲处理器且未达按需扩容上限时在锁外创建新处理器，
+// 否则等待其他任务归还，直到 ctx 结束
+func (p *processorPool) Acquire(ctx context.Context) (*OCRProcessor, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errPoolClosed
+		}
+
+		if n := len(p.idle); n > 0 {
+			proc := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			proc.inUse = true
+			p.mu.Unlock()
+			return proc, nil
+		}
+
+		limit := min(p.minSize, p.maxSize)
+		if p.growOnDemand {
+			limit = p.maxSize
+		}
+		if len(p.processors)+p.creating < limit {
+			p.creating++
+			p.mu.Unlock()
+
+			proc, err := p.spawn(true)
+			if err == nil {
+				return proc, nil
+			}
+			if errors.Is(err, errPoolClosed) || p.Size() == 0 {
+				return nil, err
+			}
+			// 已有其他处理器，等待它们被归还
+			p.mu.Lock()
+		}
+
+		changed := p.changed
+		p.waiting++
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.waiting--
+			p.mu.Unlock()
+			return nil, ctx.Err()
+		case <-changed:
+			p.mu.Lock()
+			p.waiting--
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Release 归还处理器，已退役的处理器不会重新进入空闲列表；
+// 上限被调低后超出 maxSize 的处理器在归还时关闭
+func (p *processorPool) Release(proc *OCRProcessor) {
+	p.mu.Lock()
+	proc.inUse = false
+	proc.lastUsed = time.Now()
+
+	if proc.retired || p.closed {
+		p.mu.Unlock()
+		return
+	}
+	if len(p.processors) > p.maxSize {
+		proc.retired = true
+		p.processors = removeProcessor(p.processors, proc)
+		p.notifyLocked()
+		p.mu.Unlock()
+		proc.closeEngine()
+		return
+	}
+	p.idle = append(p.idle, proc)
+	p.notifyLocked()
+	p.mu.Unlock()
+}
+
+// Retire 将处理器从池中移除并关闭其引擎
+func (p *processorPool) Retire(proc *OCRProcessor) {
+	p.mu.Lock()
+	if proc.retired {
+		p.mu.Unlock()
+		return
+	}
+	proc.retired = true
+	p.processors = removeProcessor(p.processors, proc)
+	p.idle = removeProcessor(p.idle, proc)
+	p.notifyLocked()
+	p.mu.Unlock()
+
+	proc.closeEngine()
+}
+
+// Grow 在不超过上限的前提下创建最多 n 个空闲处理器，返回成功创建的数量
+func (p *processorPool) Grow(n int) (int, error) {
+	created := 0
+	for i := 0; i < n; i++ {
+		if !p.reserve() {
+			break
+		}
+		if _, err := p.spawn(false); err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// reserve 预留一个创建名额
+func (p *processorPool) reserve() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.processors)+p.creating >= p.maxSize {
+		return false
+	}
+	p.creating++
+	return true
+}
+
+// spawn 在锁外启动引擎并加入池中，调用前必须已通过 reserve 预留名额
+func (p *processorPool) spawn(inUse bool) (*OCRProcessor, error) {
+	engine, err := p.newEngine()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.creating--
+	defer p.notifyLocked()
+
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		go engine.Close()
+		return nil, errPoolClosed
+	}
+
+	now := time.Now()
+	p.nextID++
+	proc := &OCRProcessor{
+		id:        p.nextID,
+		processor: engine,
+		createdAt: now,
+		lastUsed:  now,
+		inUse:     inUse,
+	}
+	p.processors = append(p.processors, proc)
+	if !inUse {
+		p.idle = append(p.idle, proc)
+	}
+	return proc, nil
+}
+
+// SetBounds 调整处理器数量的上下限，超出新上限的空闲处理器立即关闭，
+// 正在使用的处理器在归还时关闭；低于新下限的部分由定期预热补足
+func (p *processorPool) SetBounds(minSize, maxSize int) {
+	p.mu.Lock()
+	p.minSize = minSize
+	p.maxSize = maxSize
+
+	var victims []*OCRProcessor
+	for len(p.processors) > p.maxSize && len(p.idle) > 0 {
+		proc := p.idle[0]
+		proc.retired = true
+		p.idle = p.idle[1:]
+		p.processors = removeProcessor(p.processors, proc)
+		victims = append(victims, proc)
+	}
+	p.notifyLocked()
+	p.mu.Unlock()
+
+	for _, proc := range victims {
+		proc.closeEngine()
+	}
+}
+
+// age 返回处理器引擎的运行时间，引擎被替换后从替换时算起
+func (p *processorPool) age(proc *OCRProcessor) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Since(proc.createdAt)
+}
+
+// markRestarted 在处理器引擎被替换后重置其创建时间
+func (p *processorPool) markRestarted(proc *OCRProcessor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proc.createdAt = time.Now()
+}
+
+// markRestartFailed 标记处理器的引擎重启失败，归还时回收
+func (p *processorPool) markRestartFailed(proc *OCRProcessor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proc.restartFailed = true
+}
+
+// restartFailed 返回处理器的引擎是否重启失败
+func (p *processorPool) restartFailed(proc *OCRProcessor) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return proc.restartFailed
+}
+
+// recordError 记录处理器最近一次错误
+func (p *processorPool) recordError(proc *OCRProcessor, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	proc.lastError = err.Error()
+}
+
+// Find 按 ID 查找存活的处理器
+func (p *processorPool) Find(id int64) *OCRProcessor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, proc := range p.processors {
+		if proc.id == id {
+			return proc
+		}
+	}
+	return nil
+}
+
+// RequestRestart 标记处理器在归还时重启，处理器已空闲时返回 false，由调用方直接回收
+func (p *processorPool) RequestRestart(proc *OCRProcessor) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !proc.inUse {
+		return false
+	}
+	proc.restartPending = true
+	return true
+}
+
+// restartRequested 返回处理器是否被请求重启
+func (p *processorPool) restartRequested(proc *OCRProcessor) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return proc.restartPending
+}
+
+// checkout 如果处理器仍处于空闲状态，则将其取出供维护任务使用
+func (p *processorPool) checkout(proc *OCRProcessor) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, candidate := range p.idle {
+		if candidate == proc {
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
+			proc.inUse = true
+			return true
+		}
+	}
+	return false
+}
+
+// ScaleDown 关闭空闲超过 idleTimeout 的处理器，但保留至少 minSize 个
+func (p *processorPool) ScaleDown(idleTimeout time.Duration) int {
+	p.mu.Lock()
+	var victims []*OCRProcessor
+	for i := 0; i < len(p.idle) && len(p.processors) > p.minSize; {
+		proc := p.idle[i]
+		if time.Since(proc.lastUsed) <= idleTimeout {
+			i++
+			continue
+		}
+		proc.retired = true
+		p.idle = append(p.idle[:i], p.idle[i+1:]...)
+		p.processors = removeProcessor(p.processors, proc)
+		victims = append(victims, proc)
+	}
+	if len(victims) > 0 {
+		p.notifyLocked()
+	}
+	p.mu.Unlock()
+
+	for _, proc := range victims {
+		proc.closeEngine()
+	}
+	return len(victims)
+}
+
+// Shrink 关闭最久未使用的空闲处理器，最多 n 个，并保留至少 minSize 个处理器和 keepIdle 个空闲处理器
+func (p *processorPool) Shrink(n, keepIdle int) int {
+	p.mu.Lock()
+	var victims []*OCRProcessor
+	for len(victims) < n && len(p.idle) > keepIdle && len(p.processors) > p.minSize {
+		oldest := 0
+		for i, proc := range p.idle {
+			if proc.lastUsed.Before(p.idle[oldest].lastUsed) {
+				oldest = i
+			}
+		}
+		proc := p.idle[oldest]
+		proc.retired = true
+		p.idle = append(p.idle[:oldest], p.idle[oldest+1:]...)
+		p.processors = removeProcessor(p.processors, proc)
+		victims = append(victims, proc)
+	}
+	if len(victims) > 0 {
+		p.notifyLocked()
+	}
+	p.mu.Unlock()
+
+	for _, proc := range victims {
+		proc.closeEngine()
+	}
+	return len(victims)
+}
+
+// IdleProcessors 返回当前空闲处理器的副本
+func (p *processorPool) IdleProcessors() []*OCRProcessor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*OCRProcessor(nil), p.idle...)
+}
+
+// Processors 返回所有存活处理器的副本
+func (p *processorPool) Processors() []*OCRProcessor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*OCRProcessor(nil), p.processors...)
+}
+
+// Describe 返回所有存活处理器的状态
+func (p *processorPool) Describe() []processorInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	infos := make([]processorInfo, 0, len(p.processors))
+	for _, proc := range p.processors {
+		state := "idle"
+		if proc.inUse {
+			state = "in_use"
+		}
+		infos = append(infos, processorInfo{
+			ID:             proc.id,
+			State:          state,
+			Jobs:           atomic.LoadInt64(&proc.jobCount),
+			ActiveCalls:    atomic.LoadInt64(&proc.usageCount),
+			Age:            time.Since(proc.createdAt).Seconds(),
+			LastUsed:       proc.lastUsed,
+			LastError:      proc.lastError,
+			RestartPending: proc.restartPending,
+		})
+	}
+	return infos
+}
+
+// Size 返回存活处理器数量
+func (p *processorPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.processors)
+}
+
+// Snapshot 返回处理器池的当前计数
+func (p *processorPool) Snapshot() poolSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return poolSnapshot{
+		Total:    len(p.processors),
+		Idle:     len(p.idle),
+		InUse:    len(p.processors) - len(p.idle),
+		Creating: p.creating,
+		Waiting:  p.waiting,
+		Min:      p.minSize,
+		Max:      p.maxSize,
+	}
+}
+
+// Close 关闭处理器池及其中的所有处理器，正在等待的 Acquire 会返回 errPoolClosed
+func (p *processorPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	processors := p.processors
+	for _, proc := range processors {
+		proc.retired = true
+	}
+	p.processors = nil
+	p.idle = nil
+	p.notifyLocked()
+	p.mu.Unlock()
+
+	for _, proc := range processors {
+		proc.closeEngine()
+	}
+}
+
+func removeProcessor(processors []*OCRProcessor, processor *OCRProcessor) []*OCRProcessor {
+	for i, p := range processors {
+		if p == processor {
+			return append(processors[:i], processors[i+1:]...)
+		}
+	}
+	return processors
+}
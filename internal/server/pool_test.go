@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// fakeEngine 是不启动子进程的 OCR 引擎，记录是否被关闭
+type fakeEngine struct {
+	closed atomic.Bool
+	closes atomic.Int32
+	broken atomic.Bool // 为 true 时识别失败
+}
+
+func (e *fakeEngine) OcrAndParse(image []byte) (paddleocr.Result, error) {
+	if e.closed.Load() {
+		return paddleocr.Result{}, errors.New("engine closed")
+	}
+	if e.broken.Load() {
+		return paddleocr.Result{}, errors.New("engine crashed")
+	}
+	return paddleocr.Result{Code: paddleocr.CodeSuccess}, nil
+}
+
+func (e *fakeEngine) Close() error {
+	e.closes.Add(1)
+	if e.closed.Swap(true) {
+		return errors.New("engine closed twice")
+	}
+	return nil
+}
+
+// fakeFactory 创建 fakeEngine 并记录创建的所有引擎
+type fakeFactory struct {
+	mu      sync.Mutex
+	engines []*fakeEngine
+	fail    atomic.Bool
+	delay   time.Duration
+}
+
+func (f *fakeFactory) newEngine() (ocrEngine, error) {
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	if f.fail.Load() {
+		return nil, errors.New("engine start failed")
+	}
+	e := &fakeEngine{}
+	f.mu.Lock()
+	f.engines = append(f.engines, e)
+	f.mu.Unlock()
+	return e, nil
+}
+
+// openEngines 返回尚未关闭的引擎数量
+func (f *fakeFactory) openEngines() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, e := range f.engines {
+		if !e.closed.Load() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPoolAcquireRelease(t *testing.T) {
+	f := &fakeFactory{}
+	p := newProcessorPool(f.newEngine, 1, 2, true)
+	defer p.Close()
+
+	a, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("the same processor was handed out twice")
+	}
+	if snap := p.Snapshot(); snap.Total != 2 || snap.InUse != 2 || snap.Idle != 0 {
+		t.Fatalf("snapshot = %+v, want 2 in use", snap)
+	}
+
+	p.Release(a)
+	c, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != a {
+		t.Error("Acquire did not reuse the released processor")
+	}
+	if n := len(f.engines); n != 2 {
+		t.Errorf("created %d engines, want 2", n)
+	}
+}
+
+func TestPoolAcquireHonoursContext(t *testing.T) {
+	f := &fakeFactory{}
+	p := newProcessorPool(f.newEngine, 1, 1, true)
+	defer p.Close()
+
+	if _, err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Acquire returned after %v", elapsed)
+	}
+	if snap := p.Snapshot(); snap.Waiting != 0 {
+		t.Errorf("waiting = %d after cancelled Acquire, want 0", snap.Waiting)
+	}
+}
+
+func TestPoolCloseWakesWaiters(t *testing.T) {
+	f := &fakeFactory{}
+	p := newProcessorPool(f.newEngine, 1, 1, true)
+
+	held, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const waiters = 4
+	errs := make(chan error, waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			_, err := p.Acquire(context.Background())
+			errs <- err
+		}()
+	}
+	waitFor(t, func() bool { return p.Snapshot().Waiting == waiters })
+
+	p.Close()
+	for i := 0; i < waiters; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, errPoolClosed) {
+				t.Errorf("waiter got %v, want errPoolClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Close did not wake the waiting Acquire calls")
+		}
+	}
+
+	// 关闭后归还的处理器不会重新进入池中
+	p.Release(held)
+	if _, err := p.Acquire(context.Background()); !errors.Is(err, errPoolClosed) {
+		t.Errorf("Acquire after Close = %v, want errPoolClosed", err)
+	}
+	if n := f.openEngines(); n != 0 {
+		t.Errorf("%d engines still open after Close", n)
+	}
+}
+
+func TestPoolFactoryFailure(t *testing.T) {
+	f := &fakeFactory{}
+	f.fail.Store(true)
+	p := newProcessorPool(f.newEngine, 1, 2, true)
+	defer p.Close()
+
+	// 池中没有处理器时直接返回创建错误
+	if _, err := p.Acquire(context.Background()); err == nil {
+		t.Fatal("Acquire succeeded with a failing factory")
+	}
+	if snap := p.Snapshot(); snap.Total != 0 || snap.Creating != 0 {
+		t.Fatalf("snapshot = %+v after failed start, want empty", snap)
+	}
+	if n, err := p.Grow(1); n != 0 || err == nil {
+		t.Fatalf("Grow = %d, %v, want 0 and an error", n, err)
+	}
+
+	// 已有处理器时创建失败的请求等待其他处理器归还
+	f.fail.Store(false)
+	held, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.fail.Store(true)
+
+	got := make(chan *OCRProcessor, 1)
+	go func() {
+		proc, err := p.Acquire(context.Background())
+		if err != nil {
+			t.Errorf("Acquire = %v, want to wait for the held processor", err)
+		}
+		got <- proc
+	}()
+	waitFor(t, func() bool { return p.Snapshot().Waiting == 1 })
+	p.Release(held)
+
+	select {
+	case proc := <-got:
+		if proc != held {
+			t.Error("waiter did not receive the released processor")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter was not woken by Release")
+	}
+}
+
+func TestPoolWithoutGrowOnDemandStaysAtMin(t *testing.T) {
+	f := &fakeFactory{}
+	// 由自动扩缩容负责扩容时，Acquire 只补足到 minSize
+	p := newProcessorPool(f.newEngine, 1, 3, false)
+	defer p.Close()
+
+	if _, err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire = %v, want to wait instead of growing past minSize", err)
+	}
+	if n := p.Size(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+	if n, err := p.Grow(2); n != 2 || err != nil {
+		t.Errorf("Grow = %d, %v, want the autoscaler to grow up to maxSize", n, err)
+	}
+}
+
+func TestPoolGrowLimitedToMax(t *testing.T) {
+	f := &fakeFactory{}
+	// 未开启按需扩容时 Acquire 只补足到 minSize，且不超过 maxSize
+	p := newProcessorPool(f.newEngine, 3, 1, false)
+	defer p.Close()
+
+	if _, err := p.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire = %v, want to wait instead of growing past maxSize", err)
+	}
+	if n := p.Size(); n != 1 {
+		t.Errorf("size = %d, want 1", n)
+	}
+}
+
+func TestPoolSetBoundsRetiresExcess(t *testing.T) {
+	f := &fakeFactory{}
+	p := newProcessorPool(f.newEngine, 1, 3, true)
+	defer p.Close()
+
+	if n, err := p.Grow(2); n != 2 || err != nil {
+		t.Fatalf("Grow = %d, %v", n, err)
+	}
+	busy, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 空闲的处理器立即关闭，正在使用的在归还时关闭
+	p.SetBounds(1, 1)
+	if snap := p.Snapshot(); snap.Total != 1 || snap.Idle != 0 {
+		t.Fatalf("snapshot after SetBounds = %+v, want only the busy processor", snap)
+	}
+	p.Release(busy)
+	if snap := p.Snapshot(); snap.Total != 1 || snap.Idle != 1 {
+		t.Fatalf("snapshot after Release = %+v, want one idle processor", snap)
+	}
+	if n := f.openEngines(); n != 1 {
+		t.Errorf("%d engines open, want 1", n)
+	}
+}
+
+func TestPoolScaleDownAndShrinkKeepMin(t *testing.T) {
+	f := &fakeFactory{}
+	p := newProcessorPool(f.newEngine, 2, 5, true)
+	defer p.Close()
+
+	if _, err := p.Grow(5); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Shrink(2, 0); n != 2 {
+		t.Errorf("Shrink closed %d, want 2", n)
+	}
+	if n := p.ScaleDown(0); n != 1 {
+		t.Errorf("ScaleDown closed %d, want 1 to keep minSize", n)
+	}
+	if n := p.Shrink(5, 0); n != 0 {
+		t.Errorf("Shrink closed %d below minSize", n)
+	}
+	if n := p.Size(); n != 2 {
+		t.Errorf("size = %d, want 2", n)
+	}
+	if n := f.openEngines(); n != 2 {
+		t.Errorf("%d engines open, want 2", n)
+	}
+}
+
+// TestPoolConcurrentScaleDownAndHealthCheck 在并发获取和归还的同时运行缩容和健康检查式的取出归还，
+// 检查不会死锁、处理器不会被重复分配、不会超过上限，且所有被移出池的引擎都已关闭。需配合 -race 运行
+func TestPoolConcurrentScaleDownAndHealthCheck(t *testing.T) {
+	f := &fakeFactory{delay: time.Millisecond}
+	const maxSize = 4
+	p := newProcessorPool(f.newEngine, 1, maxSize, true)
+
+	var holders sync.Map // *OCRProcessor -> struct{}
+	var maxTotal atomic.Int64
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				proc, err := p.Acquire(ctx)
+				if err != nil {
+					if ctx.Err() == nil {
+						t.Errorf("Acquire: %v", err)
+					}
+					return
+				}
+				if _, loaded := holders.LoadOrStore(proc, struct{}{}); loaded {
+					t.Errorf("processor %p handed out twice", proc)
+				}
+				if _, err := proc.processor.OcrAndParse(nil); err != nil {
+					t.Errorf("processor %p: %v", proc, err)
+				}
+				if total := int64(p.Size()); total > maxTotal.Load() {
+					maxTotal.Store(total)
+				}
+				holders.Delete(proc)
+				p.Release(proc)
+			}
+		}()
+	}
+
+	// 维护任务：缩容，以及与 HealthCheck 相同的取出和归还
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			p.ScaleDown(0)
+			p.Shrink(1, 0)
+			for _, proc := range p.IdleProcessors() {
+				if !p.checkout(proc) {
+					continue
+				}
+				if _, loaded := holders.LoadOrStore(proc, struct{}{}); loaded {
+					t.Errorf("processor %p checked out while in use", proc)
+				}
+				holders.Delete(proc)
+				p.Release(proc)
+			}
+			p.Snapshot()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("deadlock: workers still running 5s after the deadline, snapshot %+v", p.Snapshot())
+	}
+
+	if max := maxTotal.Load(); max > maxSize {
+		t.Errorf("pool grew to %d processors, max is %d", max, maxSize)
+	}
+	snap := p.Snapshot()
+	if snap.InUse != 0 || snap.Waiting != 0 || snap.Creating != 0 {
+		t.Errorf("snapshot after all released = %+v", snap)
+	}
+	if open := f.openEngines(); open != snap.Total {
+		t.Errorf("%d engines open but pool holds %d processors", open, snap.Total)
+	}
+
+	p.Close()
+	if open := f.openEngines(); open != 0 {
+		t.Errorf("%d engines open after Close", open)
+	}
+}
+
+// waitFor 轮询等待条件成立，最多 1 秒
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within 1s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
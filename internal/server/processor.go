@@ -2,9 +2,12 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"image"
+	"net/http"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,122 +15,285 @@ import (
 	"github.com/cenkalti/backoff"
 	"github.com/doraemonkeys/paddleocr"
 	"github.com/suifei/ocr-server/internal/imgproc"
+	"github.com/suifei/ocr-server/internal/utils"
 	"github.com/suifei/ocr-server/pkg/ocrengine"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type OCRProcessor struct {
-	processor  *paddleocr.Ppocr
+	id         int64
+	processor  ocrEngine
 	usageCount int64
-	lastUsed   time.Time
+	jobCount   int64
 	mutex      sync.Mutex
-	inUse      bool
+	// closed 表示引擎已关闭且未能替换，由 mutex 保护
+	closed bool
+
+	// 以下字段由 processorPool.mu 保护
+	createdAt      time.Time
+	lastUsed       time.Time
+	lastError      string
+	inUse          bool
+	retired        bool
+	restartPending bool // 管理员请求重启，归还时回收
+	restartFailed  bool // 引擎重启失败，归还时回收
 }
 
 type ocrTask struct {
+	ID        string
 	ImagePath string
 	ImageData []byte
+	Annotate  *imgproc.AnnotateOptions
 	Response  chan ocrResponse
+	Priority  taskPriority
+	// Ctx 是请求的 context，客户端断开或超过截止时间时被取消
+	Ctx context.Context
+	// EnqueuedAt 是任务进入队列的时间，用于统计排队等待
+	EnqueuedAt time.Time
+	// CacheKey 非空时识别成功的结果写入缓存
+	CacheKey string
 }
 
-func (s *Server) createOCRProcessor() (*OCRProcessor, error) {
-	processor, err := ocrengine.NewOCREngine(s.config.OCRExePath)
-	if err != nil {
+// newOCREngine 启动一个新的 PaddleOCR-json 进程，熔断器打开时直接失败
+func (s *Server) newOCREngine() (ocrEngine, error) {
+	if err := s.breaker.Allow(); err != nil {
 		return nil, err
 	}
+	reported := false
+	defer func() {
+		if !reported {
+			s.breaker.Failure(errEngineAborted)
+		}
+	}()
 
-	return &OCRProcessor{
-		processor: processor.Ppocr,
-		lastUsed:  time.Now(),
-	}, nil
+	engine, err := ocrengine.NewOCREngine(s.cfg().OCRExePath)
+	reported = true
+	if err != nil {
+		s.breaker.Failure(err)
+		return nil, err
+	}
+	s.breaker.Started()
+	return engine.Process, nil
 }
 
-func (s *Server) getAvailableProcessor(ctx context.Context) *OCRProcessor {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-			if len(s.idleProcessors) > 0 {
-				processor := s.idleProcessors[len(s.idleProcessors)-1]
-				s.idleProcessors = s.idleProcessors[:len(s.idleProcessors)-1]
-				s.activeProcessors = append(s.activeProcessors, processor)
-				processor.inUse = true
-				return processor
-			}
-
-			if len(s.activeProcessors) < s.config.MaxProcessors {
-				processor, err := s.createOCRProcessor()
-				if err == nil {
-					processor.inUse = true
-					s.activeProcessors = append(s.activeProcessors, processor)
-					return processor
-				}
-			}
-
-			s.processorCond.Wait()
-		}
+// closeEngine 关闭处理器的引擎进程
+func (p *OCRProcessor) closeEngine() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil
 	}
+	p.closed = true
+	return p.processor.Close()
 }
 
-func (s *Server) releaseProcessor(processor *OCRProcessor) {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	processor.inUse = false
-	processor.lastUsed = time.Now()
-
-	if len(s.activeProcessors) > s.config.MinProcessors {
-		for i, p := range s.activeProcessors {
-			if p == processor {
-				s.activeProcessors = append(s.activeProcessors[:i], s.activeProcessors[i+1:]...)
-				s.idleProcessors = append(s.idleProcessors, processor)
-				break
-			}
-		}
+// rejectTask 在无法获取处理器时直接回复任务
+func (s *Server) rejectTask(ctx context.Context, task ocrTask, err error) {
+	lg := utils.LoggerFromContext(task.Ctx)
+	if errors.Is(err, errPoolClosed) || ctx.Err() != nil {
+		apiErr := newAPIError(ErrCodeShuttingDown, http.StatusServiceUnavailable, "服务器正在关闭")
+		lg.Infow("无可用处理器，服务器正在关闭", apiErr.logAttrs()...)
+		task.Response <- errorResponse(apiErr)
+	} else if errors.Is(err, errCircuitOpen) {
+		apiErr := engineUnavailableError()
+		lg.Warningw("无法获取处理器", apiErr.logAttrs()...)
+		task.Response <- errorResponse(apiErr)
+	} else {
+		apiErr := newAPIError(ErrCodeEngineError, http.StatusServiceUnavailable, "无法启动 OCR 处理器: %v", err)
+		lg.Errorw("无法获取处理器", apiErr.logAttrs()...)
+		task.Response <- errorResponse(apiErr)
 	}
+	s.updateStats(time.Since(task.EnqueuedAt), false)
+}
 
-	s.processorCond.Signal()
+// skipCanceledTask 回复一个在出队前已被取消的任务
+func (s *Server) skipCanceledTask(task ocrTask, err error) {
+	apiErr := contextError(err)
+	utils.LoggerFromContext(task.Ctx).Infow("任务已取消，跳过处理", apiErr.logAttrs("priority", task.Priority.String())...)
+	atomic.AddInt64(&s.stats.CanceledRequests, 1)
+	task.Response <- errorResponse(apiErr)
+	s.updateStats(time.Since(task.EnqueuedAt), false)
 }
-func (s *Server) processTask(ctx context.Context, task ocrTask) {
+
+func (s *Server) processTask(ctx context.Context, task ocrTask, processor *OCRProcessor) {
 	defer s.wg.Done()
 
 	startTime := time.Now()
+	timings := &stageTimings{QueueWait: startTime.Sub(task.EnqueuedAt)}
+	s.autoscaler.observeWait(timings.QueueWait)
+	s.metrics.queueWait.Observe(timings.QueueWait.Seconds())
+
+	// 任务在服务器关闭或请求取消时都应停止
+	taskCtx, cancel := context.WithCancel(task.Ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
 
-	processor := s.getAvailableProcessor(ctx)
-	if processor == nil {
-		log.Println("无可用处理器，服务器正在关闭")
-		task.Response <- ocrResponse{Error: "服务器正在关闭"}
+	taskCtx, span := s.tracer.Start(taskCtx, "process_task", trace.WithAttributes(
+		attribute.Int64("processor.id", processor.id),
+		attribute.String("ocr.priority", task.Priority.String()),
+		attribute.Float64("ocr.queue_wait_ms", millis(timings.QueueWait)),
+	))
+	defer span.End()
+	lg := utils.LoggerFromContext(task.Ctx).With("processor_id", processor.id)
+	taskCtx = utils.ContextWithLogger(taskCtx, lg)
+
+	defer func() {
+		if r := recover(); r != nil {
+			s.recoverTaskPanic(task, processor, r, startTime)
+		}
+	}()
+
+	imgdata, apiErr := s.preprocessImage(taskCtx, task.ImageData, timings)
+	s.metrics.preprocess.Observe((timings.Decode + timings.Preprocess + timings.Encode).Seconds())
+	if apiErr != nil {
+		traceError(span, apiErr)
+		lg.Infow("图像预处理失败", apiErr.logAttrs()...)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
 		s.updateStats(time.Since(startTime), false)
+		s.releaseOrRecycle(processor)
+		processor = nil
+		return
+	}
+
+	if err := taskCtx.Err(); err != nil {
+		s.releaseOrRecycle(processor)
+		processor = nil
+		if ctx.Err() != nil {
+			task.Response <- timings.attach(task, errorResponse(newAPIError(ErrCodeShuttingDown, http.StatusServiceUnavailable, "服务器正在关闭，任务已中止")))
+			s.updateStats(time.Since(startTime), false)
+			return
+		}
+		s.skipCanceledTask(task, err)
 		return
 	}
 
-	log.Printf("使用处理器 %p 处理任务", processor)
-	result, err := s.performOCRWithRetry(ctx, processor, task)
+	lg.Infow("开始处理任务", "priority", task.Priority.String(), "queue_wait_ms", millis(timings.QueueWait))
+	engineStart := time.Now()
+	result, err := s.performOCRWithRetry(taskCtx, processor, imgdata, timings)
+	s.metrics.engine.Observe(time.Since(engineStart).Seconds())
+	recordError(span, err)
+	atomic.AddInt64(&processor.jobCount, 1)
 
-	if err != nil {
-		log.Printf("OCR 任务失败: %v", err)
-		task.Response <- ocrResponse{Error: err.Error()}
+	elapsedMS := millis(time.Since(task.EnqueuedAt))
+	if err != nil && ctx.Err() != nil {
+		apiErr := newAPIError(ErrCodeShuttingDown, http.StatusServiceUnavailable, "服务器正在关闭，任务已中止")
+		lg.Warningw("任务因服务器关闭而中止", apiErr.logAttrs("engine_err", err, "duration_ms", elapsedMS)...)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
+		s.updateStats(time.Since(startTime), false)
+	} else if err != nil && task.Ctx.Err() != nil {
+		apiErr := contextError(task.Ctx.Err())
+		lg.Infow("任务在识别过程中取消", apiErr.logAttrs("duration_ms", elapsedMS)...)
+		atomic.AddInt64(&s.stats.CanceledRequests, 1)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
+		s.updateStats(time.Since(startTime), false)
+	} else if errors.Is(err, errCircuitOpen) {
+		apiErr := engineUnavailableError()
+		lg.Warningw("OCR 任务失败", apiErr.logAttrs("engine_err", err, "duration_ms", elapsedMS)...)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
+		s.updateStats(time.Since(startTime), false)
+	} else if err != nil {
+		apiErr := newAPIError(ErrCodeEngineError, http.StatusInternalServerError, "%v", err)
+		lg.Errorw("OCR 任务失败", apiErr.logAttrs("duration_ms", elapsedMS)...)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
 		s.updateStats(time.Since(startTime), false)
 	} else if result.Code != paddleocr.CodeSuccess {
-		log.Printf("OCR 任务失败，错误代码: %s", result.Msg)
-		task.Response <- ocrResponse{Error: fmt.Sprintf("OCR 失败: %s", result.Msg)}
+		apiErr := newAPIError(ErrCodeOCRFailed, http.StatusUnprocessableEntity, "OCR 失败: %s", result.Msg)
+		lg.Warningw("OCR 任务失败", apiErr.logAttrs("engine_code", result.Code, "duration_ms", elapsedMS)...)
+		task.Response <- timings.attach(task, errorResponse(apiErr))
 		s.updateStats(time.Since(startTime), false)
 	} else {
-		log.Println("OCR 任务成功完成")
-		task.Response <- ocrResponse{Data: result.Data}
+		lg.Infow("OCR 任务成功完成", "status", http.StatusOK, "lines", len(result.Data), "duration_ms", elapsedMS)
+		if task.CacheKey != "" {
+			s.storeResult(task.CacheKey, result.Data)
+		}
+		task.Response <- timings.attach(task, s.successResponse(task, result.Data))
 		s.updateStats(time.Since(startTime), true)
 	}
 
-	s.releaseProcessor(processor)
+	s.releaseOrRecycle(processor)
+	processor = nil
+}
+
+// recoverTaskPanic 处理任务中的 panic：记录堆栈、回复客户端并退役正在使用的处理器
+func (s *Server) recoverTaskPanic(task ocrTask, processor *OCRProcessor, r interface{}, startTime time.Time) {
+	atomic.AddInt64(&s.stats.PanicCount, 1)
+	lg := utils.LoggerFromContext(task.Ctx)
+	lg.Errorw("任务发生 panic", "code", ErrCodeInternal, "status", http.StatusInternalServerError, "err", r, "stack", string(debug.Stack()))
+
+	// 响应通道有缓冲，若已发送过结果则不再重复发送
+	select {
+	case task.Response <- errorResponse(newAPIError(ErrCodeInternal, http.StatusInternalServerError, "处理任务时发生内部错误")):
+	default:
+	}
+	s.updateStats(time.Since(startTime), false)
+
+	if processor != nil {
+		lg.Warningw("处理器已退役", "processor_id", processor.id)
+		s.pool.Retire(processor)
+	}
+}
+
+// preprocessImage 解码图像并进行灰度化和二值化，返回 PNG 数据，各步骤耗时记录到 timings
+func (s *Server) preprocessImage(ctx context.Context, data []byte, timings *stageTimings) ([]byte, *apiError) {
+	_, span := s.tracer.Start(ctx, "decode", trace.WithAttributes(attribute.Int("image.bytes", len(data))))
+	start := time.Now()
+	img, err := imgproc.BytesToImage(data)
+	timings.Decode = time.Since(start)
+	if err != nil {
+		apiErr := newAPIError(ErrCodeInvalidImage, http.StatusBadRequest, "解码图像失败: %v", err)
+		traceError(span, apiErr)
+		span.End()
+		return nil, apiErr
+	}
+	bounds := img.Bounds()
+	span.SetAttributes(attribute.Int("image.width", bounds.Dx()), attribute.Int("image.height", bounds.Dy()))
+	span.End()
+
+	// 二值化
+	threshold := s.cfg().ThresholdValue
+	thresholdMode := imgproc.ThresholdMode(s.cfg().ThresholdMode)
+	_, span = s.tracer.Start(ctx, "preprocess", trace.WithAttributes(
+		attribute.Int("threshold.mode", int(thresholdMode)),
+		attribute.Int("threshold.value", threshold),
+	))
+	start = time.Now()
+	processedImg := imgproc.ProcessImage(img, uint8(threshold), thresholdMode)
+	timings.Preprocess = time.Since(start)
+	span.End()
+
+	_, span = s.tracer.Start(ctx, "encode")
+	defer span.End()
+	start = time.Now()
+	imgdata, err := imgproc.GrayImageToPNGBytes(processedImg)
+	timings.Encode = time.Since(start)
+	if err != nil {
+		apiErr := newAPIError(ErrCodeInternal, http.StatusInternalServerError, "编码预处理图像失败: %v", err)
+		traceError(span, apiErr)
+		return nil, apiErr
+	}
+	span.SetAttributes(attribute.Int("image.bytes", len(imgdata)))
+	return imgdata, nil
 }
 
-func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcessor, task ocrTask) (paddleocr.Result, error) {
+// performOCRWithRetry 调用引擎识别，失败时重新初始化引擎并退避重试。
+// 引擎调用时间累加到 timings.Engine，其余时间（退避和重新初始化）记为 timings.Retry
+func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcessor, imgdata []byte, timings *stageTimings) (paddleocr.Result, error) {
 	var result paddleocr.Result
 	var err error
 
+	lg := utils.LoggerFromContext(ctx)
+	ctx, engineSpan := s.tracer.Start(ctx, "engine")
+	defer engineSpan.End()
+	attempts := 0
+
+	start := time.Now()
+	defer func() {
+		timings.Retry = time.Since(start) - timings.Engine
+		engineSpan.SetAttributes(attribute.Int("ocr.attempts", attempts))
+	}()
+
 	operation := func() error {
 		select {
 		case <-ctx.Done():
@@ -139,44 +305,64 @@ func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcesso
 			processor.mutex.Lock()
 			defer processor.mutex.Unlock()
 
-			var buff []byte
-
-			if task.ImagePath != "" {
-				buff, err = os.ReadFile(task.ImagePath)
-			} else {
-				buff = task.ImageData
+			// 熔断器打开时不再重试，立即失败
+			if allowErr := s.breaker.Allow(); allowErr != nil {
+				err = allowErr
+				return backoff.Permanent(allowErr)
 			}
-			// 二值化
-			threshold := s.config.ThresholdValue
-			thresholdMode := imgproc.ThresholdMode(s.config.ThresholdMode)
-			img, _ := imgproc.BytesToImage(buff)
-			processedImg := imgproc.ProcessImage(img, uint8(threshold), thresholdMode)
-			imgdata, _ := imgproc.GrayImageToPNGBytes(processedImg)
-			task.ImageData = imgdata
-			result, err = processor.processor.OcrAndParse(task.ImageData)
+			reported := false
+			defer func() {
+				if !reported {
+					s.breaker.Failure(errEngineAborted)
+				}
+			}()
 
-			processor.lastUsed = time.Now()
+			attempts++
+			_, span := s.tracer.Start(ctx, "ocr_attempt", trace.WithAttributes(
+				attribute.Int("ocr.attempt", attempts),
+			))
+			callStart := time.Now()
+			result, err = processor.processor.OcrAndParse(imgdata)
+			reported = true
+			timings.Engine += time.Since(callStart)
+			recordError(span, err)
+			span.End()
 
 			if err != nil {
-				log.Printf("OCR 处理器失败: %v。尝试重新初始化...", err)
+				s.breaker.Failure(err)
+				s.pool.recordError(processor, err)
+				lg.Warningw("OCR 处理器失败，尝试重新初始化", "err", err, "attempt", attempts)
+				_, restartSpan := s.tracer.Start(ctx, "engine_restart")
+				defer restartSpan.End()
 				processor.processor.Close()
-				newProcessor, initErr := s.createOCRProcessor()
+				processor.closed = true
+				newEngine, initErr := s.pool.newEngine()
+				recordError(restartSpan, initErr)
 				if initErr != nil {
-					log.Printf("重新初始化 OCR 处理器失败: %v", initErr)
-					return err // 返回原始错误，让 backoff 重试
+					// 引擎已关闭，不能再重试或放回池中，归还时退役
+					lg.Errorw("重新初始化 OCR 处理器失败", "err", initErr)
+					s.pool.markRestartFailed(processor)
+					return backoff.Permanent(err)
 				}
-				*processor = *newProcessor
-				processor.inUse = true
-				log.Printf("成功重新初始化 OCR 处理器")
+				processor.processor = newEngine
+				processor.closed = false
+				s.pool.markRestarted(processor)
+				atomic.StoreInt64(&processor.jobCount, 0)
+				lg.Info("成功重新初始化 OCR 处理器")
 				return err // 返回原始错误，让 backoff 重试
 			}
 
+			s.breaker.Success()
 			return nil
 		}
 	}
 
 	backOff := backoff.NewExponentialBackOff()
 	backOff.MaxElapsedTime = 2 * time.Minute
+	// 不要在请求截止时间之后继续重试
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backOff.MaxElapsedTime {
+		backOff.MaxElapsedTime = time.Until(deadline)
+	}
 
 	err = backoff.Retry(operation, backoff.WithContext(backOff, ctx))
 	if err != nil {
@@ -185,3 +371,43 @@ func (s *Server) performOCRWithRetry(ctx context.Context, processor *OCRProcesso
 
 	return result, nil
 }
+
+// successResponse 构造识别成功的响应，需要时附带标注图像
+func (s *Server) successResponse(task ocrTask, data []paddleocr.Data) ocrResponse {
+	response := ocrResponse{Data: data}
+	if task.Annotate != nil {
+		annotated, err := s.annotateResult(task, data)
+		if err != nil {
+			utils.LoggerFromContext(task.Ctx).Warningw("生成标注图像失败", "err", err)
+			response.Code = ErrCodeInternal
+			response.Error = fmt.Sprintf("生成标注图像失败: %v", err)
+		} else {
+			response.AnnotatedImage = annotated
+		}
+	}
+	return response
+}
+
+// annotateResult 在原始图像上绘制识别框，返回 base64 编码的 PNG
+func (s *Server) annotateResult(task ocrTask, data []paddleocr.Data) (string, error) {
+	img, err := imgproc.BytesToImage(task.ImageData)
+	if err != nil {
+		return "", err
+	}
+	boxes := make([]imgproc.AnnotationBox, 0, len(data))
+	for _, d := range data {
+		box := imgproc.AnnotationBox{Score: d.Score}
+		for _, pt := range d.Rect {
+			if len(pt) >= 2 {
+				box.Points = append(box.Points, image.Pt(pt[0], pt[1]))
+			}
+		}
+		boxes = append(boxes, box)
+	}
+
+	pngData, err := imgproc.ImageToPNGBytes(imgproc.Annotate(img, boxes, *task.Annotate))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(pngData), nil
+}
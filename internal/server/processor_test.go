@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/config"
+)
+
+func TestFailedRestartRetiresProcessor(t *testing.T) {
+	s, err := NewServer(config.Config{MinProcessors: 1, MaxProcessors: 1, QueueSize: 10, BreakerThreshold: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeFactory{}
+	s.pool = newProcessorPool(f.newEngine, 1, 1, true)
+	defer s.pool.Close()
+
+	processor, err := s.pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := processor.processor.(*fakeEngine)
+	engine.broken.Store(true)
+	f.fail.Store(true)
+
+	// 引擎重启失败后立即放弃，不在已关闭的引擎上继续重试
+	start := time.Now()
+	if _, err := s.performOCRWithRetry(context.Background(), processor, []byte("image"), &stageTimings{}); err == nil {
+		t.Fatal("performOCRWithRetry succeeded with a broken engine")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("performOCRWithRetry kept retrying for %v", elapsed)
+	}
+
+	// 归还时处理器被退役而不是放回空闲队列，引擎只关闭一次
+	s.releaseOrRecycle(processor)
+	s.wg.Wait()
+	if snap := s.pool.Snapshot(); snap.Total != 0 || snap.Idle != 0 {
+		t.Errorf("after failed restart: total %d, idle %d, want the processor retired", snap.Total, snap.Idle)
+	}
+	if n := engine.closes.Load(); n != 1 {
+		t.Errorf("engine closed %d times, want 1", n)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errQueueFull = errors.New("任务队列已满")
+
+// taskPriority 是任务的调度优先级，数值越小优先级越高
+type taskPriority int
+
+const (
+	priorityHigh taskPriority = iota
+	priorityNormal
+	priorityBulk
+	numPriorities
+)
+
+var priorityNames = [numPriorities]string{"high", "normal", "bulk"}
+
+func (p taskPriority) String() string {
+	return priorityNames[p]
+}
+
+// parsePriority 解析优先级名称，空字符串返回 normal
+func parsePriority(name string) (taskPriority, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return priorityNormal, true
+	}
+	for i, n := range priorityNames {
+		if n == name {
+			return taskPriority(i), true
+		}
+	}
+	return priorityNormal, false
+}
+
+// taskQueue 为每个优先级维护一个 FIFO 通道，出队时按权重进行平滑加权轮询，
+// 保证低优先级任务在高负载下仍能按比例获得调度而不会饿死。
+// 所有优先级共享 queue_size 个名额，排队任务总数不超过 queue_size
+type taskQueue struct {
+	lanes [numPriorities]chan ocrTask
+	slots chan struct{} // 入队前占用一个名额，出队后释放
+	ready chan struct{} // 每入队一个任务放入一个令牌
+
+	mu      sync.Mutex // 保护 weights 和 current，权重可在运行时调整
+	weights [numPriorities]int
+	current [numPriorities]int // 平滑加权轮询状态
+}
+
+func newTaskQueue(size int, weights [numPriorities]int) *taskQueue {
+	q := &taskQueue{
+		slots: make(chan struct{}, size),
+		ready: make(chan struct{}, size),
+	}
+	// 每个优先级通道都能容纳全部名额，占到名额后写入通道不会阻塞
+	for i := range q.lanes {
+		q.lanes[i] = make(chan ocrTask, size)
+	}
+	q.SetWeights(weights)
+	return q
+}
+
+// SetWeights 设置各优先级的调度权重，非正数按 1 处理
+func (q *taskQueue) SetWeights(weights [numPriorities]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, w := range weights {
+		if w <= 0 {
+			w = 1
+		}
+		q.weights[i] = w
+	}
+	q.current = [numPriorities]int{}
+}
+
+// Push 将任务放入对应优先级的队列，队列已满时最多等待 timeout（为 0 时立即返回），
+// 等待期间 ctx 结束则返回 ctx 的错误
+func (q *taskQueue) Push(ctx context.Context, task ocrTask, timeout time.Duration) error {
+	if timeout <= 0 {
+		select {
+		case q.slots <- struct{}{}:
+		default:
+			return errQueueFull
+		}
+	} else {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case q.slots <- struct{}{}:
+		case <-timer.C:
+			return errQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	q.lanes[task.Priority] <- task
+	q.ready <- struct{}{}
+	return nil
+}
+
+// Ready 返回任务到达通知通道，每接收一个令牌后必须调用一次 pop
+func (q *taskQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// pop 按权重从非空队列中取出一个任务，调用前必须已从 Ready 接收到令牌
+func (q *taskQueue) pop() ocrTask {
+	for {
+		q.mu.Lock()
+		best := -1
+		total := 0
+		for i, lane := range q.lanes {
+			if len(lane) == 0 {
+				continue
+			}
+			q.current[i] += q.weights[i]
+			total += q.weights[i]
+			if best < 0 || q.current[i] > q.current[best] {
+				best = i
+			}
+		}
+		if best < 0 {
+			q.mu.Unlock()
+			// 令牌先于任务可见的情况不会发生，这里只是防御
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		q.current[best] -= total
+		q.mu.Unlock()
+		task := <-q.lanes[best]
+		<-q.slots
+		return task
+	}
+}
+
+// Len 返回所有优先级队列中的任务总数
+func (q *taskQueue) Len() int {
+	n := 0
+	for _, lane := range q.lanes {
+		n += len(lane)
+	}
+	return n
+}
+
+// Depths 返回每个优先级队列的长度
+func (q *taskQueue) Depths() map[string]int {
+	depths := make(map[string]int, numPriorities)
+	for i, lane := range q.lanes {
+		depths[priorityNames[i]] = len(lane)
+	}
+	return depths
+}
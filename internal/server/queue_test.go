@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTaskQueueSharesCapacityAcrossPriorities(t *testing.T) {
+	q := newTaskQueue(3, [numPriorities]int{1, 1, 1})
+	ctx := context.Background()
+
+	// queue_size 是所有优先级合计的容量，而不是每个优先级各自的容量
+	for _, p := range []taskPriority{priorityHigh, priorityNormal, priorityBulk} {
+		if err := q.Push(ctx, ocrTask{Priority: p}, 0); err != nil {
+			t.Fatalf("Push(%s) = %v", p, err)
+		}
+	}
+	for _, p := range []taskPriority{priorityHigh, priorityNormal, priorityBulk} {
+		if err := q.Push(ctx, ocrTask{Priority: p}, 0); !errors.Is(err, errQueueFull) {
+			t.Errorf("Push(%s) on a full queue = %v, want errQueueFull", p, err)
+		}
+	}
+	if err := q.Push(ctx, ocrTask{Priority: priorityBulk}, 20*time.Millisecond); !errors.Is(err, errQueueFull) {
+		t.Errorf("Push with timeout on a full queue = %v, want errQueueFull", err)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", q.Len())
+	}
+
+	// 出队释放名额，等待中的 Push 随即成功
+	pushed := make(chan error, 1)
+	go func() { pushed <- q.Push(ctx, ocrTask{Priority: priorityBulk}, time.Second) }()
+	<-q.Ready()
+	q.pop()
+	if err := <-pushed; err != nil {
+		t.Fatalf("Push after pop = %v", err)
+	}
+	if q.Len() != 3 {
+		t.Errorf("Len = %d, want 3", q.Len())
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := q.Push(canceled, ocrTask{Priority: priorityHigh}, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Push with canceled context = %v, want context.Canceled", err)
+	}
+}
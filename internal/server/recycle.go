@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/utils"
+	"github.com/suifei/ocr-server/pkg/ocrengine"
+)
+
+// processorRSS 返回处理器子进程的常驻内存（字节）
+func processorRSS(processor *OCRProcessor) (uint64, error) {
+	proc, ok := processor.processor.(*ocrengine.Process)
+	if !ok {
+		return 0, ocrengine.ErrRSSUnsupported
+	}
+	return ocrengine.ResidentMemory(proc.PID())
+}
+
+// recycleReason 检查处理器是否超过任务数、存活时间或内存限制，返回需要回收的原因
+func (s *Server) recycleReason(processor *OCRProcessor) string {
+	if s.pool.restartFailed(processor) {
+		return "引擎重启失败"
+	}
+	if s.pool.restartRequested(processor) {
+		return "管理员请求重启"
+	}
+
+	if limit := s.cfg().MaxJobsPerProcessor; limit > 0 {
+		if jobs := atomic.LoadInt64(&processor.jobCount); jobs >= limit {
+			return fmt.Sprintf("已处理 %d 个任务，达到上限 %d", jobs, limit)
+		}
+	}
+
+	if limit := s.cfg().MaxProcessorAge; limit > 0 {
+		if age := s.pool.age(processor); age >= limit {
+			return fmt.Sprintf("已运行 %v，达到上限 %v", age.Round(time.Second), limit)
+		}
+	}
+
+	if limit := s.cfg().MaxRSSMB; limit > 0 {
+		rss, err := processorRSS(processor)
+		if err == nil && rss >= uint64(limit)<<20 {
+			return fmt.Sprintf("常驻内存 %d MB，达到上限 %d MB", rss>>20, limit)
+		}
+	}
+
+	return ""
+}
+
+// releaseOrRecycle 在任务完成后归还处理器；超过限制或被请求重启的处理器会被退役并异步补充新的处理器。
+// 所有使用完处理器的路径都应通过它归还，否则管理员请求的重启不会生效
+func (s *Server) releaseOrRecycle(processor *OCRProcessor) {
+	reason := s.recycleReason(processor)
+	if reason == "" {
+		s.pool.Release(processor)
+		return
+	}
+	s.recycleProcessor(processor, reason)
+}
+
+func (s *Server) recycleProcessor(processor *OCRProcessor, reason string) {
+	utils.LogInfo("回收处理器 %d：%s", processor.id, reason)
+	atomic.AddInt64(&s.stats.RecycledProcessors, 1)
+	s.pool.Retire(processor)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if _, err := s.pool.Grow(1); err != nil {
+			utils.LogWarning("补充回收的处理器失败：%v", err)
+		}
+	}()
+}
+
+// recycleIdleProcessors 检查空闲处理器，回收超过限制的处理器。
+// 未配置任何限制时仍会回收被请求重启的处理器（重启请求可能恰好在处理器归还时到达）
+func (s *Server) recycleIdleProcessors() {
+	limited := s.cfg().MaxJobsPerProcessor > 0 || s.cfg().MaxProcessorAge > 0 || s.cfg().MaxRSSMB > 0
+
+	for _, processor := range s.pool.IdleProcessors() {
+		if !limited && !s.pool.restartRequested(processor) {
+			continue
+		}
+		if !s.pool.checkout(processor) {
+			continue
+		}
+		s.releaseOrRecycle(processor)
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/config"
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+// ConfigLoader 重新读取配置，供热加载使用
+type ConfigLoader func() (config.Config, error)
+
+// restartRequiredFields 是修改后需要重启才能生效的配置项（按 mapstructure 名称）
+var restartRequiredFields = map[string]bool{
+	"addr":                 true,
+	"port":                 true,
+	"queue_size":           true,
+	"log_file_path":        true,
+	"log_max_size":         true,
+	"log_max_backups":      true,
+	"log_max_age":          true,
+	"log_compress":         true,
+	"log_format":           true,
+	"autoscale_interval":   true,
+	"breaker_threshold":    true,
+	"breaker_cooldown":     true,
+	"cache_max_entries":    true,
+	"cache_max_bytes":      true,
+	"cache_ttl":            true,
+	"cache_dir":            true,
+	"cache_disk_max_bytes": true,
+	"trace_exporter":       true,
+	"trace_file":           true,
+	"trace_endpoint":       true,
+	"trace_sample_ratio":   true,
+}
+
+// reloadState 记录配置热加载的状态
+type reloadState struct {
+	mu              sync.Mutex
+	loader          ConfigLoader
+	reloads         int64
+	lastReload      time.Time
+	lastError       string
+	applied         []string
+	restartRequired []string
+}
+
+// EnableReload 启用配置热加载：收到 SIGHUP 或配置文件被修改时通过 loader 重新读取配置。
+// 两种触发方式都只向 triggers 发送通知，由同一个协程依次执行重新加载
+func (s *Server) EnableReload(loader ConfigLoader) {
+	s.reload.mu.Lock()
+	s.reload.loader = loader
+	s.reload.mu.Unlock()
+
+	// 容量为 1：已有待执行的重新加载时丢弃新的通知，它读取到的已经是最新的配置文件
+	triggers := make(chan string, 1)
+	notify := func(trigger string) {
+		select {
+		case triggers <- trigger:
+		default:
+		}
+	}
+	go func() {
+		for trigger := range triggers {
+			s.ReloadConfig(trigger)
+		}
+	}()
+
+	if err := config.WatchConfig(func() { notify("配置文件变化") }); err != nil {
+		utils.LogWarning("无法监听配置文件变化，只能通过 SIGHUP 重新加载配置: %v", err)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			notify("SIGHUP")
+		}
+	}()
+}
+
+// ReloadConfig 重新读取并校验配置，可安全修改的字段立即生效，
+// 需要重启的字段保持原值并记录下来
+func (s *Server) ReloadConfig(trigger string) error {
+	s.reload.mu.Lock()
+	defer s.reload.mu.Unlock()
+
+	if s.reload.loader == nil {
+		return nil
+	}
+
+	utils.LogInfo("重新加载配置（%s）", trigger)
+	newCfg, err := s.reload.loader()
+	if err == nil {
+		err = config.ValidateConfig(&newCfg)
+	}
+	if err != nil {
+		utils.LogError("重新加载配置失败，继续使用当前配置：%v", err)
+		s.reload.lastError = err.Error()
+		return err
+	}
+
+	oldCfg := *s.cfg()
+	applied, restart := diffConfig(oldCfg, &newCfg)
+	if len(applied) == 0 && len(restart) == 0 {
+		utils.LogInfo("配置无变化")
+		return nil
+	}
+
+	if err := utils.SetLogLevel(newCfg.LogLevel); err != nil {
+		utils.LogWarning("%v", err)
+	}
+	s.config.Store(&newCfg)
+	s.imageRoots.Store(newImageRoots(newCfg.AllowedImageDirs))
+	s.pool.SetBounds(newCfg.MinProcessors, newCfg.MaxProcessors)
+	s.queue.SetWeights([numPriorities]int{
+		newCfg.PriorityWeightHigh, newCfg.PriorityWeightNormal, newCfg.PriorityWeightBulk,
+	})
+
+	s.reload.reloads++
+	s.reload.lastReload = time.Now()
+	s.reload.lastError = ""
+	s.reload.applied = applied
+	s.reload.restartRequired = restart
+
+	if len(applied) > 0 {
+		utils.LogInfo("已应用配置项：%v", applied)
+	}
+	if len(restart) > 0 {
+		utils.LogWarning("以下配置项需要重启服务器才能生效：%v", restart)
+	}
+	return nil
+}
+
+// diffConfig 比较新旧配置，返回已修改且可立即生效的字段和需要重启的字段；
+// 需要重启的字段在 newCfg 中恢复为旧值，使当前配置与实际运行状态一致
+func diffConfig(oldCfg config.Config, newCfg *config.Config) (applied, restart []string) {
+	oldVal := reflect.ValueOf(oldCfg)
+	newVal := reflect.ValueOf(newCfg).Elem()
+	typ := oldVal.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		if reflect.DeepEqual(oldVal.Field(i).Interface(), newVal.Field(i).Interface()) {
+			continue
+		}
+		name := typ.Field(i).Tag.Get("mapstructure")
+		if restartRequiredFields[name] {
+			restart = append(restart, name)
+			newVal.Field(i).Set(oldVal.Field(i))
+		} else {
+			applied = append(applied, name)
+		}
+	}
+	return applied, restart
+}
+
+// reloadStats 返回配置热加载的状态
+func (s *Server) reloadStats() map[string]interface{} {
+	s.reload.mu.Lock()
+	defer s.reload.mu.Unlock()
+
+	stats := map[string]interface{}{
+		"enabled":          s.reload.loader != nil,
+		"reloads":          s.reload.reloads,
+		"applied":          s.reload.applied,
+		"restart_required": s.reload.restartRequired,
+		"last_error":       s.reload.lastError,
+	}
+	if !s.reload.lastReload.IsZero() {
+		stats["last_reload"] = s.reload.lastReload
+	}
+	return stats
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/doraemonkeys/paddleocr"
+	"github.com/suifei/ocr-server/internal/cache"
+	"github.com/suifei/ocr-server/internal/config"
+	"github.com/suifei/ocr-server/internal/utils"
+)
+
+// newResultCache 根据配置创建识别结果缓存，cache_max_entries 为 0 时禁用缓存
+func newResultCache(cfg config.Config) (*cache.Cache, error) {
+	if cfg.CacheMaxEntries <= 0 {
+		return nil, nil
+	}
+	if cfg.CacheDir != "" {
+		if err := checkCacheDir(cfg.CacheDir, cfg.OCRExePath); err != nil {
+			return nil, err
+		}
+	}
+	return cache.New(cache.Options{
+		MaxEntries:   cfg.CacheMaxEntries,
+		MaxBytes:     cfg.CacheMaxBytes,
+		TTL:          cfg.CacheTTL,
+		Dir:          cfg.CacheDir,
+		DiskMaxBytes: cfg.CacheDiskMaxBytes,
+	})
+}
+
+// checkCacheDir 拒绝包含工作目录或 OCR 引擎的缓存目录，磁盘缓存会清理目录中过期的文件，
+// 不能与配置文件、日志或引擎共用目录
+func checkCacheDir(dir, exePath string) error {
+	cacheDir := resolvePath(dir)
+	if wd, err := os.Getwd(); err == nil && isSubPath(cacheDir, resolvePath(wd)) {
+		return fmt.Errorf("cache_dir %s 包含工作目录 %s，请使用单独的目录", dir, wd)
+	}
+	if exePath != "" && isSubPath(cacheDir, resolvePath(exePath)) {
+		return fmt.Errorf("cache_dir %s 包含 OCR 引擎 %s，请使用单独的目录", dir, exePath)
+	}
+	return nil
+}
+
+// resolvePath 返回路径的绝对路径并尽量解析符号链接，路径不存在时只做绝对化
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	return abs
+}
+
+// resultCacheKey 由图像内容和影响识别结果的预处理、引擎选项计算缓存键
+func (s *Server) resultCacheKey(imageData []byte) string {
+	cfg := s.cfg()
+	options := fmt.Sprintf("threshold_mode=%d;threshold_value=%d;engine=%s",
+		cfg.ThresholdMode, cfg.ThresholdValue, cfg.OCRExePath)
+	return cache.Key(imageData, options)
+}
+
+// lookupResult 查找缓存的识别结果
+func (s *Server) lookupResult(key string) ([]paddleocr.Data, bool) {
+	value, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	var data []paddleocr.Data
+	if err := json.Unmarshal(value, &data); err != nil {
+		utils.LogWarning("解析缓存的识别结果失败: %v", err)
+		return nil, false
+	}
+	return data, true
+}
+
+// storeResult 缓存成功的识别结果
+func (s *Server) storeResult(key string, data []paddleocr.Data) {
+	value, err := json.Marshal(data)
+	if err != nil {
+		utils.LogWarning("序列化识别结果失败: %v", err)
+		return
+	}
+	s.cache.Put(key, value)
+}
+
+// respondCached 直接使用缓存结果回复请求，不经过任务队列
+func (s *Server) respondCached(task ocrTask, data []paddleocr.Data) ocrResponse {
+	atomic.AddInt64(&s.stats.TotalRequests, 1)
+	atomic.AddInt64(&s.stats.SuccessfulRequests, 1)
+
+	response := s.successResponse(task, data)
+	response.Cached = true
+	return response
+}
+
+// cacheStats 返回缓存的统计信息
+func (s *Server) cacheStats() map[string]interface{} {
+	if s.cache == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	stats := s.cache.Stats()
+	hitRate := float64(0)
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total) * 100
+	}
+	return map[string]interface{}{
+		"enabled":    true,
+		"hits":       stats.Hits,
+		"disk_hits":  stats.DiskHits,
+		"misses":     stats.Misses,
+		"hit_rate":   hitRate,
+		"entries":    stats.Entries,
+		"bytes":      stats.Bytes,
+		"evictions":  stats.Evictions,
+		"disk_bytes": stats.DiskBytes,
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckCacheDir(t *testing.T) {
+	base := t.TempDir()
+	engine := filepath.Join(base, "engine", "PaddleOCR-json.exe")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{".", wd, filepath.Dir(wd), "/", filepath.Join(base, "engine"), base} {
+		if err := checkCacheDir(dir, engine); err == nil {
+			t.Errorf("checkCacheDir(%s) accepted a directory containing the working directory or engine", dir)
+		}
+	}
+	for _, dir := range []string{filepath.Join(base, "cache"), filepath.Join(wd, "cache")} {
+		if err := checkCacheDir(dir, engine); err != nil {
+			t.Errorf("checkCacheDir(%s) = %v", dir, err)
+		}
+	}
+}
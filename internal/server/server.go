@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,77 +11,132 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/doraemonkeys/paddleocr"
+	"github.com/suifei/ocr-server/internal/cache"
 	"github.com/suifei/ocr-server/internal/config"
+	"github.com/suifei/ocr-server/internal/imgproc"
+	"github.com/suifei/ocr-server/internal/tracing"
 	"github.com/suifei/ocr-server/internal/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
-	config           config.Config
-	activeProcessors []*OCRProcessor
-	idleProcessors   []*OCRProcessor
-	taskQueue        chan ocrTask
-	poolLock         sync.Mutex
-	processorCond    *sync.Cond
-	shutdownChan     chan struct{}
-	wg               sync.WaitGroup
-	stats            *ServerStats
+	config       atomic.Pointer[config.Config] // 热加载时整体替换
+	pool         *processorPool
+	queue        *taskQueue
+	shutdownChan chan struct{}
+	wg           sync.WaitGroup
+	stats        *ServerStats
+	imageClient  *http.Client
+	autoscaler   *autoscaler
+	breaker      *circuitBreaker
+	probeImage   []byte // 健康检查使用的已知文字图像
+	startedAt    time.Time
+	ready        atomic.Bool // Initialize 完成后置为 true
+	draining     atomic.Bool // 收到关闭信号后置为 true
+	// activeRequests 是正在处理的 OCR 请求数量，排空阶段等待其归零
+	activeRequests int64
+	reload         reloadState
+	cache          *cache.Cache // 为 nil 时禁用结果缓存
+	flights        *flightGroup
+	metrics        *serverMetrics
+	tracer         trace.Tracer               // 未启用链路追踪时为 no-op tracer
+	traceProvider  *tracing.Provider          // 为 nil 时未启用链路追踪
+	imageRoots     atomic.Pointer[imageRoots] // 随配置一起更新
 }
 type ServerStats struct {
-	TotalRequests         int64
-	SuccessfulRequests    int64
-	FailedRequests        int64
-	AverageProcessingTime atomic.Value // stores time.Duration
+	TotalRequests        int64
+	SuccessfulRequests   int64
+	FailedRequests       int64
+	CanceledRequests     int64
+	RecycledProcessors   int64
+	DeduplicatedRequests int64
+	PanicCount           int64
+	// Latency 记录任务处理时间的滚动窗口，替代全时段的平均值
+	Latency *latencyRecorder
 }
 
 func NewServer(cfg config.Config) (*Server, error) {
 	s := &Server{
-		config:           cfg,
-		activeProcessors: make([]*OCRProcessor, 0, cfg.MaxProcessors),
-		idleProcessors:   make([]*OCRProcessor, 0, cfg.MaxProcessors),
-		taskQueue:        make(chan ocrTask, cfg.QueueSize),
-		shutdownChan:     make(chan struct{}),
-		stats:            &ServerStats{},
+		shutdownChan: make(chan struct{}),
+		startedAt:    time.Now(),
+		flights:      newFlightGroup(),
+		stats:        &ServerStats{Latency: newLatencyRecorder()},
+		autoscaler:   &autoscaler{},
+		breaker:      newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
 	}
-	s.processorCond = sync.NewCond(&s.poolLock)
-	s.stats.AverageProcessingTime.Store(time.Duration(0))
+	s.config.Store(&cfg)
+	s.imageRoots.Store(newImageRoots(cfg.AllowedImageDirs))
+	s.queue = newTaskQueue(cfg.QueueSize, [numPriorities]int{
+		cfg.PriorityWeightHigh, cfg.PriorityWeightNormal, cfg.PriorityWeightBulk,
+	})
+	s.pool = newProcessorPool(s.newOCREngine, cfg.MinProcessors, cfg.MaxProcessors, cfg.AutoscaleInterval <= 0)
+	s.imageClient = s.newImageFetchClient()
+	s.metrics = s.newServerMetrics()
+
+	resultCache, err := newResultCache(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("创建结果缓存失败: %w", err)
+	}
+	s.cache = resultCache
+
+	traceProvider, err := newTraceProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("创建链路追踪失败: %w", err)
+	}
+	s.traceProvider = traceProvider
+	s.tracer = traceProvider.Tracer(traceServiceName)
+
+	probe, err := imgproc.ProbeImage()
+	if err != nil {
+		return nil, fmt.Errorf("生成健康检查图像失败: %w", err)
+	}
+	s.probeImage = probe
 	return s, nil
 }
 
+// cfg 返回当前生效的配置，调用方不应修改返回值
+func (s *Server) cfg() *config.Config {
+	return s.config.Load()
+}
+
 func (s *Server) Initialize() error {
-	log.Println("初始化 OCR 处理器...")
+	if s.cfg().DisableImagePath {
+		utils.LogInfo("image_path 参数已禁用")
+	} else if len(s.cfg().AllowedImageDirs) == 0 {
+		utils.LogWarning("未配置 allowed_image_dirs，image_path 可读取服务器上的任意文件")
+	} else {
+		utils.LogInfo("image_path 仅允许访问: %v", s.cfg().AllowedImageDirs)
+	}
 
-	for i := 0; i < s.config.MinProcessors; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			log.Printf("初始化处理器 %d 失败: %v", i, err)
-			return fmt.Errorf("初始化处理器 %d 失败: %w", i, err)
-		}
-		s.activeProcessors = append(s.activeProcessors, processor)
-		log.Printf("处理器 %d 已初始化", i)
+	utils.LogInfo("初始化 OCR 处理器...")
+
+	created, err := s.pool.Grow(s.cfg().MinProcessors)
+	if err != nil {
+		utils.LogWarning("初始化处理器 %d 失败: %v", created, err)
+		return fmt.Errorf("初始化处理器 %d 失败: %w", created, err)
 	}
+	utils.LogInfo("%d 个处理器已初始化", created)
 
-	log.Println("预热额外处理器...")
-	for i := 0; i < s.config.WarmUpCount; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			log.Printf("无法预热处理器 %d：%v", i, err)
-			continue
-		}
-		s.idleProcessors = append(s.idleProcessors, processor)
-		log.Printf("预热处理器 %d 已创建", i)
+	utils.LogInfo("预热额外处理器...")
+	warmed, err := s.pool.Grow(s.cfg().WarmUpCount)
+	if err != nil {
+		utils.LogWarning("无法预热处理器 %d：%v", warmed, err)
 	}
 
-	log.Printf("%d 个激活的 OCR 处理器已初始化，%d 个预热处理器已准备好。\n", len(s.activeProcessors), len(s.idleProcessors))
+	utils.LogInfo("%d 个 OCR 处理器已初始化，其中 %d 个为预热处理器。", s.pool.Size(), warmed)
+	s.ready.Store(true)
 	return nil
 }
 
 func (s *Server) Start() {
-	utils.LogInfo("启动 OCR 服务器于 %s:%d，激活处理器数量：%d",
-		s.config.Addr, s.config.Port, len(s.activeProcessors))
+	utils.LogInfo("启动 OCR 服务器于 %s:%d，处理器数量：%d",
+		s.cfg().Addr, s.cfg().Port, s.pool.Size())
 
 	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", s.config.Addr, s.config.Port),
-		Handler: http.HandlerFunc(s.handleOCR),
+		Addr:     fmt.Sprintf("%s:%d", s.cfg().Addr, s.cfg().Port),
+		Handler:  s.routes(),
+		ErrorLog: utils.StdLogger(),
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -95,7 +149,7 @@ func (s *Server) Start() {
 	go s.monitorProcessors(ctx)
 
 	go func() {
-		utils.LogInfo("HTTP 服务器监听端口 %d", s.config.Port)
+		utils.LogInfo("HTTP 服务器监听端口 %d", s.cfg().Port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			utils.LogError("HTTP 服务器错误: %v", err)
 		}
@@ -110,19 +164,30 @@ func (s *Server) waitForShutdown(ctx context.Context, cancel context.CancelFunc,
 
 	<-stop
 	utils.LogInfo("接收到关闭信号，开始优雅关闭...")
+	// 进入排空阶段：新请求返回 503，已排队和正在处理的任务继续执行
+	s.draining.Store(true)
 
-	cancel() // 取消 context，通知所有使用该 context 的 goroutine
-
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	// shutdown_timeout 是整个关闭过程的总时限：排空请求、等待 goroutine 退出和导出追踪数据共用同一个截止时间
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), s.cfg().ShutdownTimeout)
 	defer shutdownCancel()
 
+	// 排空期间继续监听，使新请求和 /readyz 能收到 503 而不是连接被拒绝
+	if s.waitForDrain(shutdownCtx) {
+		utils.LogInfo("所有请求已处理完毕")
+	} else {
+		utils.LogWarning("排空超时，仍有 %d 个请求未完成，%d 个任务在排队",
+			atomic.LoadInt64(&s.activeRequests), s.queue.Len())
+	}
+
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		utils.LogError("服务器关闭错误: %v", err)
 	}
 
+	cancel() // 取消 context，通知所有使用该 context 的 goroutine，剩余任务将收到关闭错误
+
 	close(s.shutdownChan)
 
-	// 等待所有 goroutine 完成，但设置一个超时
+	// 等待所有 goroutine 完成，直到关闭截止时间
 	done := make(chan struct{})
 	go func() {
 		s.wg.Wait()
@@ -132,32 +197,34 @@ func (s *Server) waitForShutdown(ctx context.Context, cancel context.CancelFunc,
 	select {
 	case <-done:
 		utils.LogInfo("所有 goroutine 已正常退出")
-	case <-time.After(s.config.ShutdownTimeout):
+	case <-shutdownCtx.Done():
 		utils.LogWarning("等待 goroutine 退出超时，强制退出")
 	}
 
-	s.cleanup()
+	s.cleanup(shutdownCtx)
 	utils.LogInfo("服务器已停止")
 }
 
-func (s *Server) cleanup() {
-	utils.LogInfo("清理资源...")
-
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
+// waitForDrain 等待所有 OCR 请求完成，ctx 结束时返回 false
+func (s *Server) waitForDrain(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
 
-	for i, p := range s.activeProcessors {
-		utils.LogInfo("关闭活跃处理器 %d", i)
-		p.processor.Close()
-	}
-	for i, p := range s.idleProcessors {
-		utils.LogInfo("关闭空闲处理器 %d", i)
-		p.processor.Close()
+	for atomic.LoadInt64(&s.activeRequests) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
 	}
+	return true
+}
 
-	s.activeProcessors = nil
-	s.idleProcessors = nil
-
+// cleanup 关闭处理器池并导出剩余的追踪数据，导出最多等待到 ctx 结束
+func (s *Server) cleanup(ctx context.Context) {
+	utils.LogInfo("清理资源...")
+	s.pool.Close()
+	s.shutdownTracer(ctx)
 	utils.LogInfo("所有资源已清理")
 }
 
@@ -167,13 +234,34 @@ func (s *Server) monitorProcessors(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
+	// 未启用自动伸缩时使用 nil 通道，该分支永远不会触发
+	var autoscaleC <-chan time.Time
+	if s.cfg().AutoscaleInterval > 0 {
+		autoscaleTicker := time.NewTicker(s.cfg().AutoscaleInterval)
+		defer autoscaleTicker.Stop()
+		autoscaleC = autoscaleTicker.C
+	}
+
+	// 熔断器打开后按冷却时间周期探测引擎
+	var probeC <-chan time.Time
+	if s.cfg().BreakerThreshold > 0 {
+		probeTicker := time.NewTicker(s.cfg().BreakerCooldown)
+		defer probeTicker.Stop()
+		probeC = probeTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
 			utils.LogInfo("运行定期处理器检查")
 			s.checkAndScaleDown()
+			s.recycleIdleProcessors()
 			s.PrewarmProcessors()
 			s.HealthCheck()
+		case <-autoscaleC:
+			s.autoscale()
+		case <-probeC:
+			s.probeEngine()
 		case <-ctx.Done():
 			utils.LogInfo("处理器监控正在关闭")
 			return
@@ -187,11 +275,46 @@ func (s *Server) processQueue(ctx context.Context) {
 
 	for {
 		select {
-		case task := <-s.taskQueue:
+		case <-s.queue.Ready():
+			// 先获取处理器再按优先级出队，使高优先级任务在处理器空闲时优先被调度
+			acquireStart := time.Now()
+			processor, err := s.pool.Acquire(ctx)
+			task := s.queue.pop()
+			s.traceAcquire(task, processor, acquireStart, err)
+			if err != nil {
+				s.rejectTask(ctx, task, err)
+				continue
+			}
+			// 客户端已断开或已超时的任务不再占用处理器
+			if err := task.Ctx.Err(); err != nil {
+				s.releaseOrRecycle(processor)
+				s.skipCanceledTask(task, err)
+				continue
+			}
 			s.wg.Add(1)
-			go s.processTask(ctx, task)
+			go s.processTask(ctx, task, processor)
 		case <-ctx.Done():
 			utils.LogInfo("任务队列处理器正在关闭")
+			s.abandonQueuedTasks()
+			return
+		}
+	}
+}
+
+// abandonQueuedTasks 回复所有未能在排空期限内处理的排队任务，由调度协程在退出前调用
+func (s *Server) abandonQueuedTasks() {
+	abandoned := 0
+	for {
+		select {
+		case <-s.queue.Ready():
+			task := s.queue.pop()
+			task.Response <- errorResponse(newAPIError(ErrCodeShuttingDown, http.StatusServiceUnavailable, "服务器正在关闭，任务未被处理"))
+			s.updateStats(time.Since(task.EnqueuedAt), false)
+			abandoned++
+		default:
+			if abandoned > 0 {
+				utils.LogWarning("服务器关闭，放弃了 %d 个排队任务", abandoned)
+			}
 			return
 		}
 	}
@@ -204,88 +327,94 @@ func (s *Server) updateStats(processingTime time.Duration, success bool) {
 	} else {
 		atomic.AddInt64(&s.stats.FailedRequests, 1)
 	}
-
-	// 更新平均处理时间
-	oldAvg := s.stats.AverageProcessingTime.Load().(time.Duration)
-	newAvg := oldAvg + (processingTime-oldAvg)/time.Duration(s.stats.TotalRequests)
-	s.stats.AverageProcessingTime.Store(newAvg)
+	s.stats.Latency.Record(processingTime, success)
 }
 
 func (s *Server) checkAndScaleDown() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	log.Println("检查是否需要缩减处理器数量")
-
-	for i := len(s.activeProcessors) - 1; i >= s.config.MinProcessors; i-- {
-		processor := s.activeProcessors[i]
-		if atomic.LoadInt64(&processor.usageCount) <= s.config.DegradeThreshold &&
-			time.Since(processor.lastUsed) > s.config.IdleTimeout {
-			s.activeProcessors = s.activeProcessors[:i]
-			s.idleProcessors = append(s.idleProcessors, processor)
-			log.Printf("处理器已移至空闲池。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
-		}
-	}
+	utils.LogInfo("检查是否需要缩减处理器数量")
 
-	maxIdleProcessors := s.config.MaxProcessors - len(s.activeProcessors)
-	for len(s.idleProcessors) > maxIdleProcessors {
-		processor := s.idleProcessors[len(s.idleProcessors)-1]
-		s.idleProcessors = s.idleProcessors[:len(s.idleProcessors)-1]
-		processor.processor.Close()
-		log.Printf("关闭多余的空闲处理器。空闲：%d", len(s.idleProcessors))
+	if n := s.pool.ScaleDown(s.cfg().IdleTimeout); n > 0 {
+		snap := s.pool.Snapshot()
+		utils.LogInfo("关闭了 %d 个空闲超时的处理器。总数：%d，空闲：%d", n, snap.Total, snap.Idle)
 	}
 }
 
 func (s *Server) PrewarmProcessors() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	log.Println("预热处理器")
+	utils.LogInfo("预热处理器")
 
-	targetIdleCount := s.config.WarmUpCount - len(s.idleProcessors)
-	for i := 0; i < targetIdleCount; i++ {
-		processor, err := s.createOCRProcessor()
-		if err != nil {
-			log.Printf("无法预热处理器：%v", err)
-			continue
+	snap := s.pool.Snapshot()
+	target := s.cfg().WarmUpCount - snap.Idle - snap.Creating
+	if missing := s.cfg().MinProcessors - snap.Total - snap.Creating; missing > target {
+		target = missing
+	}
+	if target > 0 {
+		if _, err := s.pool.Grow(target); err != nil {
+			utils.LogWarning("无法预热处理器：%v", err)
 		}
-		s.idleProcessors = append(s.idleProcessors, processor)
-		log.Printf("创建新的预热处理器。总空闲：%d", len(s.idleProcessors))
 	}
-	log.Printf("预热完成。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
+
+	snap = s.pool.Snapshot()
+	utils.LogInfo("预热完成。总数：%d，使用中：%d，空闲：%d", snap.Total, snap.InUse, snap.Idle)
 }
 
-func (s *Server) HealthCheck() {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
+// HealthCheck 逐个检查空闲处理器，正在处理任务的处理器会被跳过。
+// 检查期间处理器被取出池外，不会阻塞其他请求获取处理器。
+// 每个处理器需要识别出探测图像中的已知文字才算健康。
+func (s *Server) HealthCheck() []healthResult {
+	utils.LogInfo("开始对空闲处理器进行健康检查")
+
+	var results []healthResult
+	for _, processor := range s.pool.IdleProcessors() {
+		id := processor.id
+		if !s.pool.checkout(processor) {
+			utils.LogInfo("处理器 %d 正在使用，跳过健康检查", id)
+			results = append(results, healthResult{ID: id, Status: "skipped"})
+			continue
+		}
 
-	log.Println("开始对所有处理器进行健康检查")
+		utils.LogInfo("检查处理器 %d 的健康状态", id)
+		if err := s.probeProcessor(processor); err != nil {
+			utils.LogWarning("处理器 %d 未通过健康检查：%v，已退役", id, err)
+			s.pool.Retire(processor)
+			results = append(results, healthResult{ID: id, Status: "retired", Error: err.Error()})
+			continue
+		}
+		utils.LogInfo("处理器 %d 通过健康检查", id)
+		s.releaseOrRecycle(processor)
+		results = append(results, healthResult{ID: id, Status: "healthy"})
+	}
 
-	s.healthCheckProcessors(s.activeProcessors)
-	s.healthCheckProcessors(s.idleProcessors)
+	snap := s.pool.Snapshot()
+	utils.LogInfo("健康检查完成。总数：%d，使用中：%d，空闲：%d", snap.Total, snap.InUse, snap.Idle)
+	return results
+}
 
-	log.Printf("健康检查完成。激活：%d，空闲：%d", len(s.activeProcessors), len(s.idleProcessors))
+// healthResult 是单个处理器的健康检查结果
+type healthResult struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"` // healthy、retired 或 skipped
+	Error  string `json:"error,omitempty"`
 }
 
-func (s *Server) healthCheckProcessors(processors []*OCRProcessor) {
-	for i, processor := range processors {
-		processor.mutex.Lock()
-		log.Printf("检查处理器 %d 的健康状态", i)
-		_, err := processor.processor.OcrAndParse([]byte("Hello World"))
-		processor.mutex.Unlock()
-
-		if err != nil {
-			log.Printf("处理器 %d 未通过健康检查：%v", i, err)
-			log.Printf("尝试重新初始化处理器 %d", i)
-			newProcessor, err := s.createOCRProcessor()
-			if err != nil {
-				log.Printf("无法重新初始化处理器 %d：%v", i, err)
-				continue
-			}
-			*processor = *newProcessor
-			log.Printf("成功重新初始化处理器 %d", i)
-		} else {
-			log.Printf("处理器 %d 通过健康检查", i)
-		}
+// probeProcessor 让处理器识别探测图像，并校验识别出的文字
+func (s *Server) probeProcessor(processor *OCRProcessor) error {
+	processor.mutex.Lock()
+	result, err := processor.processor.OcrAndParse(s.probeImage)
+	processor.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if result.Code != paddleocr.CodeSuccess {
+		return fmt.Errorf("识别失败，错误代码 %d: %s", result.Code, result.Msg)
 	}
+
+	lines := make([]string, 0, len(result.Data))
+	for _, d := range result.Data {
+		lines = append(lines, d.Text)
+	}
+	if !imgproc.MatchesProbeText(lines) {
+		return fmt.Errorf("识别结果 %q 与探测文字 %q 不匹配", lines, imgproc.ProbeText)
+	}
+	return nil
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// flightCall 是一次正在进行的识别，相同内容和选项的请求共享其结果。
+// 以下字段由领头请求在 finish 之前写入，done 关闭后只读
+type flightCall struct {
+	done chan struct{}
+	// settled 为 false 表示领头请求被取消、超时、服务器正在关闭或在入队前被拒绝，
+	// 结果与图像本身无关，等待者需重新竞争领头
+	settled bool
+	data    []paddleocr.Data // 识别成功时的结果
+	err     *apiError        // 识别失败时的错误，等待者直接返回相同错误而不再重复识别
+}
+
+// settle 根据领头请求收到的响应记录共享结果：识别结果和识别失败由等待者共享，
+// 取消、超时和服务器关闭只与领头请求本身有关，不共享
+func (c *flightCall) settle(resp ocrResponse) {
+	if data, ok := resp.Data.([]paddleocr.Data); ok {
+		c.data = data
+		c.settled = true
+		return
+	}
+	switch resp.Code {
+	case "", ErrCodeTimeout, ErrCodeClientClosed, ErrCodeShuttingDown:
+		return
+	}
+	c.err = newAPIError(resp.Code, resp.status, "%s", resp.Error)
+	c.settled = true
+}
+
+// flightGroup 合并并发的相同请求，使同一图像只占用一个处理器
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// join 加入 key 对应的识别；没有正在进行的识别时返回 leader=true，调用方必须随后调用 finish
+func (g *flightGroup) join(key string) (call *flightCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call = &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish 发布领头请求的结果（由 settle 记录）并唤醒所有等待者
+func (g *flightGroup) finish(key string, call *flightCall) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	close(call.done)
+}
+
+// awaitFlight 等待相同请求的识别结果。返回 leader 不为 nil 时调用方成为领头请求，
+// 需要自行提交任务并在结束后调用 finish；返回 shared 不为 nil 时直接使用其中的结果或错误
+func (s *Server) awaitFlight(ctx context.Context, key string) (leader, shared *flightCall, err error) {
+	for {
+		call, isLeader := s.flights.join(key)
+		if isLeader {
+			return call, nil, nil
+		}
+
+		select {
+		case <-call.done:
+			if call.settled {
+				atomic.AddInt64(&s.stats.DeduplicatedRequests, 1)
+				return nil, call, nil
+			}
+			// 领头请求被取消或超时，重新竞争领头
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// respondShared 使用其他请求的识别结果回复请求
+func (s *Server) respondShared(task ocrTask, data []paddleocr.Data) ocrResponse {
+	atomic.AddInt64(&s.stats.TotalRequests, 1)
+	atomic.AddInt64(&s.stats.SuccessfulRequests, 1)
+	return s.successResponse(task, data)
+}
+
+// respondSharedError 使用其他请求的识别错误回复请求
+func (s *Server) respondSharedError(apiErr *apiError) ocrResponse {
+	atomic.AddInt64(&s.stats.TotalRequests, 1)
+	atomic.AddInt64(&s.stats.FailedRequests, 1)
+	return errorResponse(apiErr)
+}
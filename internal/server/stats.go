@@ -1,28 +1,23 @@
 package server
 
 import (
-	"log"
 	"sync/atomic"
-	"time"
 )
 
 func (s *Server) GetStats() map[string]interface{} {
-	s.poolLock.Lock()
-	defer s.poolLock.Unlock()
-
-	activeCount := 0
+	snap := s.pool.Snapshot()
 	totalUsage := int64(0)
-	for _, p := range s.activeProcessors {
-		if p.inUse {
-			activeCount++
-		}
+	for _, p := range s.pool.Processors() {
 		totalUsage += atomic.LoadInt64(&p.usageCount)
 	}
 
 	totalRequests := atomic.LoadInt64(&s.stats.TotalRequests)
 	successfulRequests := atomic.LoadInt64(&s.stats.SuccessfulRequests)
 	failedRequests := atomic.LoadInt64(&s.stats.FailedRequests)
-	averageProcessingTime := s.stats.AverageProcessingTime.Load().(time.Duration)
+	panicCount := atomic.LoadInt64(&s.stats.PanicCount)
+	canceledRequests := atomic.LoadInt64(&s.stats.CanceledRequests)
+	recycledProcessors := atomic.LoadInt64(&s.stats.RecycledProcessors)
+	deduplicatedRequests := atomic.LoadInt64(&s.stats.DeduplicatedRequests)
 
 	errorRate := float64(0)
 	if totalRequests > 0 {
@@ -34,14 +29,26 @@ func (s *Server) GetStats() map[string]interface{} {
 		"successful_requests":     successfulRequests,
 		"failed_requests":         failedRequests,
 		"error_rate":              errorRate,
-		"average_processing_time": averageProcessingTime.Seconds(),
-		"active_processors":       len(s.activeProcessors),
-		"in_use_processors":       activeCount,
-		"idle_processors":         len(s.idleProcessors),
-		"queue_length":            len(s.taskQueue),
+		"panics":                  panicCount,
+		"canceled_requests":       canceledRequests,
+		"deduplicated_requests":   deduplicatedRequests,
+		"average_processing_time": s.stats.Latency.RecentMean().Seconds(),
+		"latency":                 s.stats.Latency.Windows(),
+		"latency_history":         s.stats.Latency.History(),
+		"active_processors":       snap.Total,
+		"in_use_processors":       snap.InUse,
+		"idle_processors":         snap.Idle,
+		"creating_processors":     snap.Creating,
+		"queue_length":            s.queue.Len(),
+		"queue_depths":            s.queue.Depths(),
 		"total_usage":             totalUsage,
+		"recycled_processors":     recycledProcessors,
+		"waiting_tasks":           snap.Waiting,
+		"autoscaler":              s.autoscalerStats(),
+		"circuit_breaker":         s.breaker.Stats(),
+		"config_reload":           s.reloadStats(),
+		"cache":                   s.cacheStats(),
 	}
 
-	log.Printf("服务器统计: %+v", stats)
 	return stats
 }
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stageTimings 记录一个任务在各阶段花费的时间
+type stageTimings struct {
+	QueueWait  time.Duration
+	Decode     time.Duration
+	Preprocess time.Duration
+	Encode     time.Duration
+	// Engine 是调用 OCR 引擎的时间，多次尝试时累加
+	Engine time.Duration
+	// Retry 是重试带来的额外时间：退避等待和重新初始化引擎
+	Retry time.Duration
+	Total time.Duration
+}
+
+// stages 按处理顺序返回各阶段的名称和耗时
+func (t *stageTimings) stages() []struct {
+	name string
+	d    time.Duration
+} {
+	return []struct {
+		name string
+		d    time.Duration
+	}{
+		{"queue", t.QueueWait},
+		{"decode", t.Decode},
+		{"preprocess", t.Preprocess},
+		{"encode", t.Encode},
+		{"engine", t.Engine},
+		{"retry", t.Retry},
+		{"total", t.Total},
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// MarshalJSON 以毫秒输出各阶段耗时
+func (t *stageTimings) MarshalJSON() ([]byte, error) {
+	out := make(map[string]float64, 7)
+	for _, st := range t.stages() {
+		out[st.name+"_ms"] = millis(st.d)
+	}
+	return json.Marshal(out)
+}
+
+// serverTiming 生成 Server-Timing 响应头的值
+func (t *stageTimings) serverTiming() string {
+	parts := make([]string, 0, 7)
+	for _, st := range t.stages() {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", st.name, millis(st.d)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// setServerTiming 设置 Server-Timing 响应头，必须在写入状态码之前调用
+func setServerTiming(w http.ResponseWriter, t *stageTimings) {
+	if t != nil {
+		w.Header().Set("Server-Timing", t.serverTiming())
+	}
+}
+
+// attach 计算任务的总耗时并附加到响应中
+func (t *stageTimings) attach(task ocrTask, resp ocrResponse) ocrResponse {
+	t.Total = time.Since(task.EnqueuedAt)
+	resp.Timings = t
+	return resp
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/suifei/ocr-server/internal/config"
+	"github.com/suifei/ocr-server/internal/tracing"
+	"github.com/suifei/ocr-server/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceServiceName 是导出的追踪数据中的服务名，同时用作 tracer 名称
+const traceServiceName = "ocr-server"
+
+// newTraceProvider 根据配置创建 OpenTelemetry tracer provider，未配置导出方式时返回 nil，
+// 此时 Tracer 返回不记录任何 span 的 no-op tracer
+func newTraceProvider(cfg config.Config) (*tracing.Provider, error) {
+	if cfg.TraceExporter == "" {
+		return nil, nil
+	}
+	provider, err := tracing.New(tracing.Options{
+		Exporter:    cfg.TraceExporter,
+		File:        cfg.TraceFile,
+		Endpoint:    cfg.TraceEndpoint,
+		ServiceName: traceServiceName,
+		SampleRatio: cfg.TraceSampleRatio,
+		OnError: func(err error) {
+			utils.LogWarning("导出追踪数据失败: %v", err)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	utils.LogInfo("已启用链路追踪，导出方式 %s，采样比例 %.2f", cfg.TraceExporter, cfg.TraceSampleRatio)
+	return provider, nil
+}
+
+// shutdownTracer 导出剩余的 span，最多等待到 ctx 结束
+func (s *Server) shutdownTracer(ctx context.Context) {
+	if err := s.traceProvider.Shutdown(ctx); err != nil {
+		utils.LogWarning("关闭链路追踪失败: %v", err)
+	}
+}
+
+// recordError 将 span 标记为失败，err 为 nil 时不做任何事
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// traceError 在 span 上记录 API 错误
+func traceError(span trace.Span, apiErr *apiError) {
+	if apiErr != nil {
+		span.SetAttributes(attribute.String("ocr.error_code", string(apiErr.Code)))
+		recordError(span, apiErr)
+	}
+}
+
+// traceAcquire 记录调度协程为任务获取处理器的 span。处理器在出队之前获取，
+// 因此 span 从开始获取和任务入队两者中较晚的时间算起
+func (s *Server) traceAcquire(task ocrTask, processor *OCRProcessor, start time.Time, err error) {
+	if task.EnqueuedAt.After(start) {
+		start = task.EnqueuedAt
+	}
+	_, span := s.tracer.Start(task.Ctx, "acquire_processor", trace.WithTimestamp(start))
+	if processor != nil {
+		span.SetAttributes(attribute.Int64("processor.id", processor.id))
+	}
+	recordError(span, err)
+	span.End()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/suifei/ocr-server/internal/imgproc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRequestSpans(t *testing.T) {
+	s := newTestServer(t)
+	recorder := tracetest.NewSpanRecorder()
+	s.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(traceServiceName)
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	probe, err := imgproc.ProbeImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/ocr",
+		strings.NewReader(`{"image_base64":"`+base64.StdEncoding.EncodeToString(probe)+`","no_cache":true}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("OCR request = %d", resp.StatusCode)
+	}
+
+	// 根 span 接续调用方的链路，每个处理阶段都有子 span
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	waitFor(t, func() bool {
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+		return spans["POST /ocr"] != nil
+	})
+	root := spans["POST /ocr"]
+	if root.SpanKind() != trace.SpanKindServer || root.Parent().SpanID().String() != parentID {
+		t.Errorf("root span kind %v, parent %s", root.SpanKind(), root.Parent().SpanID())
+	}
+	parents := map[string]string{
+		"load_image":        "POST /ocr",
+		"enqueue":           "POST /ocr",
+		"acquire_processor": "POST /ocr",
+		"process_task":      "POST /ocr",
+		"decode":            "process_task",
+		"preprocess":        "process_task",
+		"encode":            "process_task",
+		"engine":            "process_task",
+		"ocr_attempt":       "engine",
+	}
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %s", name)
+			continue
+		}
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s is not in the caller's trace", name)
+		}
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of %s", name, parent)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/suifei/ocr-server/internal/imgproc"
+)
+
+// loadRequestImage 读取请求中的图像数据（base64、URL 或文件路径）
+func (s *Server) loadRequestImage(ctx context.Context, req ocrRequest) ([]byte, *apiError) {
+	if req.Base64Content != "" {
+		if s.cfg().MaxImageBytes > 0 && int64(base64.StdEncoding.DecodedLen(len(req.Base64Content))) > s.cfg().MaxImageBytes {
+			return nil, s.imageBytesTooLarge()
+		}
+		data, err := base64.StdEncoding.DecodeString(req.Base64Content)
+		if err != nil {
+			return nil, newAPIError(ErrCodeInvalidImage, http.StatusBadRequest, "无效的 base64 图像数据: %v", err)
+		}
+		return data, nil
+	}
+
+	if req.ImageURL != "" {
+		return s.fetchImageURL(ctx, req.ImageURL)
+	}
+
+	return s.readImageFile(req.ImagePath)
+}
+
+func (s *Server) imageBytesTooLarge() *apiError {
+	return newAPIError(ErrCodeImageTooLarge, http.StatusRequestEntityTooLarge, "图像数据超过限制 %d 字节", s.cfg().MaxImageBytes)
+}
+
+// validateImage 检查图像格式、可解码性和尺寸限制，只读取图像头部而不完整解码
+func (s *Server) validateImage(data []byte) *apiError {
+	if len(data) == 0 {
+		return newAPIError(ErrCodeInvalidImage, http.StatusBadRequest, "图像数据为空")
+	}
+
+	format := imgproc.SniffFormat(data)
+	if format == "" {
+		return newAPIError(ErrCodeUnsupportedFormat, http.StatusUnsupportedMediaType, "无法识别的图像格式")
+	}
+	if !imgproc.IsDecodable(format) {
+		return newAPIError(ErrCodeUnsupportedFormat, http.StatusUnsupportedMediaType, "不支持的图像格式: %s", format)
+	}
+
+	cfg, _, err := imgproc.DecodeConfig(data)
+	if err != nil {
+		return newAPIError(ErrCodeInvalidImage, http.StatusBadRequest, "无法解析图像: %v", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return newAPIError(ErrCodeInvalidImage, http.StatusBadRequest, "图像尺寸无效: %dx%d", cfg.Width, cfg.Height)
+	}
+	if (s.cfg().MaxImageWidth > 0 && cfg.Width > s.cfg().MaxImageWidth) ||
+		(s.cfg().MaxImageHeight > 0 && cfg.Height > s.cfg().MaxImageHeight) {
+		return newAPIError(ErrCodeImageTooLarge, http.StatusRequestEntityTooLarge,
+			"图像尺寸 %dx%d 超过限制 %dx%d", cfg.Width, cfg.Height, s.cfg().MaxImageWidth, s.cfg().MaxImageHeight)
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); s.cfg().MaxImagePixels > 0 && pixels > s.cfg().MaxImagePixels {
+		return newAPIError(ErrCodeImageTooLarge, http.StatusRequestEntityTooLarge,
+			"图像像素数 %d 超过限制 %d", pixels, s.cfg().MaxImagePixels)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package tracing sets up OpenTelemetry tracing: an SDK tracer provider exporting to
+// stdout, a file or an OTLP/HTTP collector, ratio sampling that follows the caller's
+// decision, and W3C Trace Context propagation.
+//
+// Instrumented code uses the OpenTelemetry API directly; when tracing is disabled it
+// uses a no-op tracer, so callers do not need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exporters supported by New
+const (
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+// Options configures a Provider
+type Options struct {
+	// Exporter is one of ExporterStdout, ExporterFile or ExporterOTLP
+	Exporter string
+	// File is the file spans are appended to with ExporterFile, one JSON object per line
+	File string
+	// Endpoint is the collector's base URL for ExporterOTLP, e.g. http://localhost:4318;
+	// /v1/traces is appended unless the URL already has a path
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the fraction of new traces that are sampled. Traces continued
+	// from a caller follow the caller's sampling decision.
+	SampleRatio float64
+	// OnError is called with export failures; nil ignores them
+	OnError func(error)
+}
+
+// propagator reads and writes the W3C traceparent and tracestate headers
+var propagator = propagation.TraceContext{}
+
+// Provider owns the SDK tracer provider and the file opened for ExporterFile
+type Provider struct {
+	tp     *sdktrace.TracerProvider
+	closer io.Closer
+}
+
+// New creates a tracer provider with the configured exporter and installs it, together
+// with the W3C Trace Context propagator, as the global OpenTelemetry provider so that
+// instrumented libraries join the same traces
+func New(opts Options) (*Provider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch opts.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		f, openErr := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if openErr != nil {
+			return nil, fmt.Errorf("tracing: open trace file: %w", openErr)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		closer = f
+	case ExporterOTLP:
+		var endpoint string
+		endpoint, err = tracesURL(opts.Endpoint)
+		if err == nil {
+			exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+		}
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+
+	if opts.OnError != nil {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(opts.OnError))
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return &Provider{tp: tp, closer: closer}, nil
+}
+
+// tracesURL returns the OTLP/HTTP traces URL for a collector base URL
+func tracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("tracing: invalid OTLP endpoint %q", endpoint)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// Tracer returns a named tracer. A nil provider returns a no-op tracer.
+func (p *Provider) Tracer(name string) trace.Tracer {
+	if p == nil {
+		return noop.NewTracerProvider().Tracer(name)
+	}
+	return p.tp.Tracer(name)
+}
+
+// Shutdown exports the remaining spans, waiting until ctx is done, and closes the trace file
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	err := p.tp.Shutdown(ctx)
+	if p.closer != nil {
+		err = errors.Join(err, p.closer.Close())
+	}
+	return err
+}
+
+// Extract returns a context carrying the caller's span context from the traceparent
+// header, so spans started from it join the caller's trace. Invalid headers are ignored.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Inject sets the traceparent header for the current span in ctx, if any
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
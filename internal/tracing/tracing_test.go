@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	remoteTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	remoteSpanID  = "00f067aa0ba902b7"
+)
+
+func TestExtractInject(t *testing.T) {
+	in := http.Header{}
+	in.Set("traceparent", "00-"+remoteTraceID+"-"+remoteSpanID+"-01")
+	ctx := Extract(context.Background(), in)
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsRemote() || sc.TraceID().String() != remoteTraceID || sc.SpanID().String() != remoteSpanID || !sc.IsSampled() {
+		t.Fatalf("extracted span context = %+v", sc)
+	}
+
+	out := http.Header{}
+	Inject(ctx, out)
+	if got := out.Get("traceparent"); got != in.Get("traceparent") {
+		t.Errorf("injected traceparent = %q, want %q", got, in.Get("traceparent"))
+	}
+
+	// malformed headers are ignored and nothing is injected without a span
+	in.Set("traceparent", "00-"+remoteTraceID+"-0000000000000000-01")
+	ctx = Extract(context.Background(), in)
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("malformed traceparent was extracted")
+	}
+	out = http.Header{}
+	Inject(ctx, out)
+	if got := out.Get("traceparent"); got != "" {
+		t.Errorf("traceparent injected without a span: %q", got)
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:4318":             "http://localhost:4318/v1/traces",
+		"http://localhost:4318/":            "http://localhost:4318/v1/traces",
+		"https://collector/custom/v1/spans": "https://collector/custom/v1/spans",
+	}
+	for endpoint, want := range tests {
+		if got, err := tracesURL(endpoint); err != nil || got != want {
+			t.Errorf("tracesURL(%q) = %q, %v, want %q", endpoint, got, err, want)
+		}
+	}
+	for _, endpoint := range []string{"", "localhost:4318", "ftp://collector"} {
+		if _, err := tracesURL(endpoint); err == nil {
+			t.Errorf("tracesURL(%q) succeeded", endpoint)
+		}
+	}
+}
+
+func TestNilProvider(t *testing.T) {
+	var p *Provider
+	_, span := p.Tracer("test").Start(context.Background(), "noop")
+	span.End()
+	if span.SpanContext().IsValid() || span.IsRecording() {
+		t.Error("nil provider returned a recording span")
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// fileSpan holds the fields of a stdouttrace JSON line the tests look at
+type fileSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ TraceID, SpanID string }
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	p, err := New(Options{Exporter: ExporterFile, File: path, ServiceName: "test", SampleRatio: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := p.Tracer("test")
+
+	// new traces are not sampled with ratio 0 ...
+	_, dropped := tracer.Start(context.Background(), "dropped")
+	dropped.End()
+
+	// ... but a trace continued from a sampled caller follows the caller's decision
+	h := http.Header{}
+	h.Set("traceparent", "00-"+remoteTraceID+"-"+remoteSpanID+"-01")
+	ctx, root := tracer.Start(Extract(context.Background(), h), "root", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracer.Start(ctx, "child")
+	child.End()
+	root.End()
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := make(map[string]fileSpan)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var span fileSpan
+		if err := json.Unmarshal(sc.Bytes(), &span); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		spans[span.Name] = span
+	}
+	if len(spans) != 2 {
+		t.Fatalf("exported spans = %v, want root and child", spans)
+	}
+	if r := spans["root"]; r.SpanContext.TraceID != remoteTraceID || r.Parent.SpanID != remoteSpanID {
+		t.Errorf("root = %+v, want a child of the remote span", r)
+	}
+	if c := spans["child"]; c.SpanContext.TraceID != remoteTraceID || c.Parent.SpanID != spans["root"].SpanContext.SpanID {
+		t.Errorf("child = %+v, want a child of root", c)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		requests   []*collectortrace.ExportTraceServiceRequest
+		exportErrs []error
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			t.Errorf("unexpected request %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Errorf("body is not an OTLP export request: %v", err)
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		resp, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Write(resp)
+	}))
+	defer collector.Close()
+
+	p, err := New(Options{Exporter: ExporterOTLP, Endpoint: collector.URL, ServiceName: "ocr-test", SampleRatio: 1,
+		OnError: func(err error) {
+			mu.Lock()
+			exportErrs = append(exportErrs, err)
+			mu.Unlock()
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, root := p.Tracer("test").Start(context.Background(), "root")
+	_, child := p.Tracer("test").Start(ctx, "child")
+	child.End()
+	root.End()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exportErrs) > 0 {
+		t.Errorf("export errors: %v", exportErrs)
+	}
+	names := make(map[string]bool)
+	for _, req := range requests {
+		for _, rs := range req.ResourceSpans {
+			service := ""
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					service = attr.Value.GetStringValue()
+				}
+			}
+			if service != "ocr-test" {
+				t.Errorf("service.name = %q, want ocr-test", service)
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names[span.Name] = true
+					if len(span.TraceId) != 16 || len(span.SpanId) != 8 {
+						t.Errorf("span %s has malformed ids", span.Name)
+					}
+				}
+			}
+		}
+	}
+	if !names["root"] || !names["child"] || len(names) != 2 {
+		t.Errorf("collector received spans %v, want root and child", names)
+	}
+}
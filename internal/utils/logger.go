@@ -1,30 +1,68 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/suifei/ocr-server/internal/config"
 
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var (
-	infoLogger    *log.Logger
-	warningLogger *log.Logger
-	errorLogger   *log.Logger
-)
+// logLevel 是当前的最低日志级别，低于该级别的日志不会输出，可在运行时修改
+var logLevel = new(slog.LevelVar)
+
+// defaultLogger 是不带附加字段的全局日志记录器，SetupLogger 会替换它
+var defaultLogger atomic.Pointer[Logger]
 
 func init() {
-	// 设置默认的日志记录器
-	infoLogger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lmicroseconds)
-	warningLogger = log.New(os.Stdout, "WARNING: ", log.Ldate|log.Ltime|log.Lmicroseconds)
-	errorLogger = log.New(os.Stdout, "ERROR: ", log.Ldate|log.Ltime|log.Lmicroseconds)
+	defaultLogger.Store(&Logger{l: slog.New(newHandler(os.Stdout, "text"))})
+}
+
+// SetLogLevel 设置最低日志级别，可选 info、warning、error，可在运行时调用
+func SetLogLevel(level string) error {
+	switch strings.ToLower(level) {
+	case "", "info":
+		logLevel.Set(slog.LevelInfo)
+	case "warning", "warn":
+		logLevel.Set(slog.LevelWarn)
+	case "error":
+		logLevel.Set(slog.LevelError)
+	default:
+		return fmt.Errorf("未知的日志级别: %s", level)
+	}
+	return nil
 }
+
+// newHandler 创建 text 或 json 格式的 slog 处理器，调用位置只保留文件名和行号
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.SourceKey && len(groups) == 0 {
+				if src, ok := a.Value.Any().(*slog.Source); ok {
+					a.Value = slog.StringValue(fmt.Sprintf("%s:%d", filepath.Base(src.File), src.Line))
+				}
+			}
+			return a
+		},
+	}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
 func SetupLogger(cfg config.Config) {
 	logFile := &lumberjack.Logger{
 		Filename:   cfg.LogFilePath,
@@ -35,36 +73,111 @@ func SetupLogger(cfg config.Config) {
 	}
 
 	multiWriter := io.MultiWriter(os.Stdout, logFile)
+	logger := &Logger{l: slog.New(newHandler(multiWriter, cfg.LogFormat))}
+	defaultLogger.Store(logger)
 
-	log.SetOutput(multiWriter)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	// 标准库 log 的输出（包括第三方库）同样转为结构化日志，级别为 INFO
+	slog.SetDefault(logger.l)
 
-	infoLogger = log.New(multiWriter, "INFO: ", log.Ldate|log.Ltime|log.Lmicroseconds)
-	warningLogger = log.New(multiWriter, "WARNING: ", log.Ldate|log.Ltime|log.Lmicroseconds)
-	errorLogger = log.New(multiWriter, "ERROR: ", log.Ldate|log.Ltime|log.Lmicroseconds)
+	LogInfo("日志系统初始化完成")
+}
+
+// Logger 是带有固定字段的日志记录器，用于把请求 ID 等上下文附加到每一行日志
+type Logger struct {
+	l *slog.Logger
+}
+
+// With 返回附加了给定字段的日志记录器，参数为交替的键和值
+func With(args ...interface{}) *Logger {
+	return defaultLogger.Load().With(args...)
+}
+
+// With 返回在当前字段基础上附加了给定字段的日志记录器
+func (lg *Logger) With(args ...interface{}) *Logger {
+	return &Logger{l: lg.l.With(args...)}
+}
+
+func (lg *Logger) Info(format string, v ...interface{}) {
+	lg.log(slog.LevelInfo, format, v...)
+}
+
+func (lg *Logger) Warning(format string, v ...interface{}) {
+	lg.log(slog.LevelWarn, format, v...)
+}
 
-	log.Println("日志系统初始化完成")
+func (lg *Logger) Error(format string, v ...interface{}) {
+	lg.log(slog.LevelError, format, v...)
+}
+
+// Infow 输出一条 INFO 日志，msg 原样输出，args 为交替的键和值，作为结构化字段输出
+func (lg *Logger) Infow(msg string, args ...interface{}) {
+	lg.logAttrs(slog.LevelInfo, msg, args...)
+}
+
+// Warningw 输出一条带结构化字段的 WARN 日志，参数同 Infow
+func (lg *Logger) Warningw(msg string, args ...interface{}) {
+	lg.logAttrs(slog.LevelWarn, msg, args...)
+}
+
+// Errorw 输出一条带结构化字段的 ERROR 日志，参数同 Infow
+func (lg *Logger) Errorw(msg string, args ...interface{}) {
+	lg.logAttrs(slog.LevelError, msg, args...)
+}
+
+// log 输出一条日志，调用位置取 Info/Warning/Error 或 LogInfo 等函数的调用方
+func (lg *Logger) log(level slog.Level, format string, v ...interface{}) {
+	lg.output(level, fmt.Sprintf(format, v...), nil)
+}
+
+// logAttrs 输出一条带结构化字段的日志，调用位置取 Infow 等函数的调用方
+func (lg *Logger) logAttrs(level slog.Level, msg string, args ...interface{}) {
+	lg.output(level, msg, args)
+}
+
+func (lg *Logger) output(level slog.Level, msg string, args []interface{}) {
+	ctx := context.Background()
+	if !lg.l.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	// 跳过 runtime.Callers、output、log/logAttrs 和 Info 等导出函数
+	runtime.Callers(4, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = lg.l.Handler().Handle(ctx, r)
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger 返回携带日志记录器的 context，供请求处理链路上的代码取用
+func ContextWithLogger(ctx context.Context, lg *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, lg)
+}
+
+// LoggerFromContext 返回 context 中的日志记录器，没有时返回全局日志记录器
+func LoggerFromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if lg, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+			return lg
+		}
+	}
+	return defaultLogger.Load()
 }
 
 func LogInfo(format string, v ...interface{}) {
-	logWithCaller(infoLogger, format, v...)
+	defaultLogger.Load().log(slog.LevelInfo, format, v...)
 }
 
 func LogWarning(format string, v ...interface{}) {
-	logWithCaller(warningLogger, format, v...)
+	defaultLogger.Load().log(slog.LevelWarn, format, v...)
 }
 
 func LogError(format string, v ...interface{}) {
-	logWithCaller(errorLogger, format, v...)
+	defaultLogger.Load().log(slog.LevelError, format, v...)
 }
 
-func logWithCaller(logger *log.Logger, format string, v ...interface{}) {
-	_, file, line, ok := runtime.Caller(2)
-	if !ok {
-		file = "???"
-		line = 0
-	}
-	_, file = filepath.Split(file)
-	msg := fmt.Sprintf(format, v...)
-	logger.Printf("%s:%d: %s", file, line, msg)
+// StdLogger 返回以 WARN 级别写入结构化日志的标准库 *log.Logger，
+// 供只接受 *log.Logger 的组件（如 http.Server.ErrorLog）使用
+func StdLogger() *log.Logger {
+	return slog.NewLogLogger(defaultLogger.Load().l.Handler(), slog.LevelWarn)
 }
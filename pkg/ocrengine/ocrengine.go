@@ -9,14 +9,14 @@ import (
 )
 
 type OCREngine struct {
-	*paddleocr.Ppocr
+	*Process
 	ExecutionTime time.Duration
 }
 
 func NewOCREngine(exePath string) (*OCREngine, error) {
 	startTime := time.Now()
 	enable_mkldnn := true
-	processor, err := paddleocr.NewPpocr(exePath, paddleocr.OcrArgs{
+	processor, err := StartProcess(exePath, paddleocr.OcrArgs{
 		EnableMkldnn:  &enable_mkldnn,
 	})
 	if err != nil {
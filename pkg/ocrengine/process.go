@@ -0,0 +1,250 @@
+package ocrengine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// ErrRSSUnsupported is returned by ResidentMemory on platforms without /proc
+var ErrRSSUnsupported = errors.New("当前平台不支持读取进程内存")
+
+// initCompleted is printed by PaddleOCR-json once its models are loaded
+const initCompleted = "OCR init completed."
+
+// maxInitOutput bounds what is read from the process while waiting for initCompleted
+const maxInitOutput = 64 << 10
+
+// exitWait bounds how long Wait keeps copying output after the process exits, and how
+// long a failed read waits for the exit status
+const exitWait = time.Second
+
+// Process is a PaddleOCR-json child process. It speaks the same line-based JSON
+// protocol over stdin/stdout as paddleocr.Ppocr, but starts the process itself so
+// that the PID is known and stays fixed for the lifetime of the Process.
+// Unlike paddleocr.Ppocr it never restarts the process on its own; callers recycle it.
+type Process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	reader *bufio.Reader
+	stderr *tailBuffer
+
+	mu        sync.Mutex // serializes requests
+	exited    chan struct{}
+	waitErr   error // set before exited is closed
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// StartProcess starts PaddleOCR-json and waits until its models are loaded.
+// The process runs in the executable's directory, where it looks for its models.
+func StartProcess(exePath string, args paddleocr.OcrArgs) (*Process, error) {
+	if info, err := os.Stat(exePath); err != nil || info.IsDir() {
+		return nil, fmt.Errorf("找不到 OCR 引擎可执行文件: %s", exePath)
+	}
+
+	slash := "/"
+	if runtime.GOOS == "windows" {
+		slash = "\\"
+	}
+	cmd := exec.Command("."+slash+filepath.Base(exePath), strings.Fields(args.CmdString())...)
+	if dir := filepath.Dir(exePath); dir != "." {
+		cmd.Dir = dir
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// An explicit pipe rather than StdoutPipe, so that Wait does not close the
+	// read end while a request is still reading from it
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdoutWriter
+	stderr := &tailBuffer{max: 4096}
+	cmd.Stderr = stderr
+	cmd.WaitDelay = exitWait
+
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, fmt.Errorf("启动 OCR 引擎进程失败: %w", err)
+	}
+	stdoutWriter.Close()
+
+	p := &Process{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		reader: bufio.NewReader(stdout),
+		stderr: stderr,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		p.waitErr = cmd.Wait()
+		close(p.exited)
+	}()
+
+	if err := p.waitReady(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// waitReady reads the startup output until PaddleOCR-json reports that it is ready
+func (p *Process) waitReady() error {
+	var output []byte
+	for len(output) < maxInitOutput {
+		line, err := p.reader.ReadBytes('\n')
+		output = append(output, line...)
+		if bytes.Contains(output, []byte(initCompleted)) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("OCR 引擎初始化失败: %w，输出: %s", p.exitError(err), bytes.TrimSpace(output))
+		}
+	}
+	return fmt.Errorf("OCR 引擎初始化失败: 输出过长且未找到 %q", initCompleted)
+}
+
+// PID returns the process ID of the child process
+func (p *Process) PID() int {
+	return p.cmd.Process.Pid
+}
+
+type imageRequest struct {
+	Path       string `json:"image_path,omitempty"`
+	ContentB64 []byte `json:"image_base64,omitempty"`
+}
+
+// Ocr sends an image to the process and returns the raw JSON response line
+func (p *Process) Ocr(image []byte) ([]byte, error) {
+	return p.request(imageRequest{ContentB64: image})
+}
+
+// OcrFile asks the process to recognize the image at path and returns the raw JSON response line
+func (p *Process) OcrFile(imagePath string) ([]byte, error) {
+	return p.request(imageRequest{Path: imagePath})
+}
+
+// OcrAndParse recognizes an image and parses the response
+func (p *Process) OcrAndParse(image []byte) (paddleocr.Result, error) {
+	raw, err := p.Ocr(image)
+	if err != nil {
+		return paddleocr.Result{}, err
+	}
+	return paddleocr.ParseResult(raw)
+}
+
+// OcrFileAndParse recognizes the image at path and parses the response
+func (p *Process) OcrFileAndParse(imagePath string) (paddleocr.Result, error) {
+	raw, err := p.OcrFile(imagePath)
+	if err != nil {
+		return paddleocr.Result{}, err
+	}
+	return paddleocr.ParseResult(raw)
+}
+
+func (p *Process) request(req imageRequest) ([]byte, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.exited:
+		return nil, p.exitError(nil)
+	default:
+	}
+	if _, err := p.stdin.Write(data); err != nil {
+		return nil, p.exitError(err)
+	}
+	line, err := p.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, p.exitError(err)
+	}
+	return line, nil
+}
+
+// exitError describes why the process stopped responding, including the end of its
+// stderr once it has exited
+func (p *Process) exitError(err error) error {
+	if errors.Is(err, io.EOF) {
+		// stdout was closed, so the process is exiting; wait for its status
+		select {
+		case <-p.exited:
+		case <-time.After(exitWait):
+		}
+	}
+	select {
+	case <-p.exited:
+	default:
+		return fmt.Errorf("与 OCR 引擎进程通信失败: %w", err)
+	}
+	msg := "OCR 引擎进程已退出"
+	if p.waitErr != nil {
+		msg += ": " + p.waitErr.Error()
+	}
+	if tail := strings.TrimSpace(p.stderr.String()); tail != "" {
+		msg += "，stderr: " + tail
+	}
+	return errors.New(msg)
+}
+
+// Close kills the process, interrupting a request in progress, and waits for it to exit.
+// Calls after the first return the first result.
+func (p *Process) Close() error {
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		if err := p.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			p.closeErr = err
+		}
+		// closing the read end also interrupts a request still waiting for output
+		p.stdout.Close()
+		<-p.exited
+	})
+	return p.closeErr
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, data...)
+	if over := len(b.buf) - b.max; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(data), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
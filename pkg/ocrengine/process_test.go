@@ -0,0 +1,261 @@
+package ocrengine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doraemonkeys/paddleocr"
+)
+
+// fakeChildEnv selects the behaviour of the test binary when it is started as a fake
+// PaddleOCR-json process
+const fakeChildEnv = "OCRENGINE_FAKE_CHILD"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakeChildEnv); mode != "" {
+		os.Exit(runFakeChild(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeChild speaks the PaddleOCR-json protocol on stdin/stdout. Output is written in
+// small pieces with pauses so that the parent sees partial reads. Each response echoes
+// the request's image content as the recognized text.
+func runFakeChild(mode string) int {
+	write := func(parts ...string) {
+		for _, part := range parts {
+			os.Stdout.WriteString(part)
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+
+	switch mode {
+	case "initfail":
+		write("PaddleOCR-json v1.3.1\n")
+		fmt.Fprintln(os.Stderr, "det model not found")
+		return 3
+	case "silent":
+		return 0
+	}
+	write("PaddleOCR-json v1.3.1\n", "Loading models...\n", "OCR init ", "completed.\n")
+
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, err := in.ReadBytes('\n')
+		if err != nil {
+			return 0
+		}
+		var req struct {
+			Path       string `json:"image_path"`
+			ContentB64 []byte `json:"image_base64"`
+		}
+		if err := json.Unmarshal(line, &req); err != nil {
+			write(`{"code":901,"data":"bad request"}` + "\n")
+			continue
+		}
+		text := string(req.ContentB64)
+		if req.Path != "" {
+			text = req.Path
+		}
+
+		switch mode {
+		case "crash":
+			write(`{"code":100,"da`)
+			fmt.Fprintln(os.Stderr, "fatal: out of memory")
+			return 2
+		case "hang":
+			time.Sleep(time.Hour)
+		}
+
+		if text == "" {
+			write(`{"code":101,"data":"No text found in image."}` + "\n")
+			continue
+		}
+		resp, _ := json.Marshal(map[string]interface{}{
+			"code": 100,
+			"data": []paddleocr.Data{{Rect: [][]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}}, Score: 0.5, Text: text}},
+		})
+		half := len(resp) / 2
+		write(string(resp[:half]), string(resp[half:]), "\n")
+	}
+}
+
+// startFake starts the test binary as a fake engine in the given mode
+func startFake(t *testing.T, mode string) (*Process, error) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(fakeChildEnv, mode)
+	return StartProcess(exe, paddleocr.OcrArgs{})
+}
+
+func TestProcessHandshakeAndRequests(t *testing.T) {
+	p, err := startFake(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.PID() <= 0 || p.PID() == os.Getpid() {
+		t.Errorf("PID = %d, want the child's PID", p.PID())
+	}
+
+	for _, text := range []string{"first", "second", strings.Repeat("x", 200<<10)} {
+		result, err := p.OcrAndParse([]byte(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Code != paddleocr.CodeSuccess || len(result.Data) != 1 || result.Data[0].Text != text {
+			t.Fatalf("OcrAndParse(%.20q) = code %d, %d lines", text, result.Code, len(result.Data))
+		}
+	}
+
+	result, err := p.OcrFileAndParse("/images/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Data) != 1 || result.Data[0].Text != "/images/a.png" {
+		t.Errorf("OcrFileAndParse = %+v", result)
+	}
+
+	result, err = p.OcrAndParse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Code != paddleocr.CodeNoText {
+		t.Errorf("empty image code = %d, want %d", result.Code, paddleocr.CodeNoText)
+	}
+}
+
+func TestProcessConcurrentRequestsAreSerialized(t *testing.T) {
+	p, err := startFake(t, "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				text := fmt.Sprintf("request %d/%d", i, j)
+				result, err := p.OcrAndParse([]byte(text))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(result.Data) != 1 || result.Data[0].Text != text {
+					t.Errorf("request %q got %+v", text, result.Data)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestProcessInitFailure(t *testing.T) {
+	for _, mode := range []string{"initfail", "silent"} {
+		t.Run(mode, func(t *testing.T) {
+			start := time.Now()
+			p, err := startFake(t, mode)
+			if err == nil {
+				p.Close()
+				t.Fatal("StartProcess succeeded without the init message")
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("StartProcess took %v to fail", time.Since(start))
+			}
+			if !strings.Contains(err.Error(), "OCR 引擎进程已退出") {
+				t.Errorf("error %q does not report the exit", err)
+			}
+			if mode == "initfail" && !strings.Contains(err.Error(), "det model not found") {
+				t.Errorf("error %q does not include stderr", err)
+			}
+		})
+	}
+}
+
+func TestProcessMissingExecutable(t *testing.T) {
+	if _, err := StartProcess(t.TempDir()+"/missing.exe", paddleocr.OcrArgs{}); err == nil {
+		t.Fatal("StartProcess succeeded for a missing executable")
+	}
+}
+
+func TestProcessChildExitsMidRequest(t *testing.T) {
+	p, err := startFake(t, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	_, err = p.OcrAndParse([]byte("boom"))
+	if err == nil {
+		t.Fatal("OcrAndParse succeeded although the child exited")
+	}
+	if !strings.Contains(err.Error(), "out of memory") {
+		t.Errorf("error %q does not include stderr", err)
+	}
+
+	// later requests fail immediately instead of writing to a dead process
+	start := time.Now()
+	if _, err := p.OcrAndParse([]byte("again")); err == nil {
+		t.Error("request after exit succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request after exit took %v", elapsed)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close after exit = %v", err)
+	}
+}
+
+func TestProcessCloseRacesRequest(t *testing.T) {
+	p, err := startFake(t, "hang")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := p.OcrAndParse([]byte("never answered"))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { closed <- p.Close() }()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Errorf("Close = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not return while a request was in progress")
+		}
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("request interrupted by Close returned no error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("request was not interrupted by Close")
+		}
+	}
+}
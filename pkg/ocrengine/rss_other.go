@@ -0,0 +1,8 @@
+//go:build !linux
+
+package ocrengine
+
+// ResidentMemory returns the resident set size of a process in bytes
+func ResidentMemory(pid int) (uint64, error) {
+	return 0, ErrRSSUnsupported
+}